@@ -0,0 +1,139 @@
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// version is the version of the ciphertext format
+const version byte = 1
+
+// headerSize is the size of the header of a ciphertext: its version and the ID of its key
+const headerSize = 1 + 4
+
+var (
+	// ErrUnknownKey is returned when a ciphertext is encrypted with a key missing from the key ring
+	ErrUnknownKey = errors.New("crypt: unknown key")
+	// ErrMalformedCiphertext is returned when a ciphertext can not be decrypted
+	ErrMalformedCiphertext = errors.New("crypt: malformed ciphertext")
+)
+
+// KeyRing encrypts with AES-GCM using its current key, and decrypts with any of its keys.
+// The ID of the key is embedded in the ciphertext, so that keys can be rotated while
+// the values encrypted with the previous keys can still be decrypted.
+//
+// A ciphertext is made of a version byte, the big-endian uint32 key ID,
+// the nonce and the sealed plaintext, authenticated with the version and key ID.
+type KeyRing struct {
+	mu      sync.RWMutex
+	keys    map[uint32]cipher.AEAD
+	current uint32
+}
+
+// NewKeyRing creates a new key ring of AES keys (16, 24 or 32 bytes) by ID,
+// encrypting with the key of the current ID
+func NewKeyRing(keys map[uint32][]byte, current uint32) (*KeyRing, error) {
+	r := &KeyRing{keys: make(map[uint32]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		if err := r.Add(id, key); err != nil {
+			return nil, err
+		}
+	}
+	if err := r.Use(current); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Add adds a key to the key ring
+func (r *KeyRing) Add(id uint32, key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return fmt.Errorf("crypt: key %d: %w", id, err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return fmt.Errorf("crypt: key %d: %w", id, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys[id] = aead
+	return nil
+}
+
+// Use sets the key encrypting the new values
+func (r *KeyRing) Use(id uint32) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.keys[id]; !ok {
+		return fmt.Errorf("%w: %d", ErrUnknownKey, id)
+	}
+	r.current = id
+	return nil
+}
+
+// Current returns the ID of the key encrypting the new values
+func (r *KeyRing) Current() uint32 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.current
+}
+
+// Encrypt encrypts a plaintext with the current key
+func (r *KeyRing) Encrypt(plaintext []byte) ([]byte, error) {
+	r.mu.RLock()
+	id, aead := r.current, r.keys[r.current]
+	r.mu.RUnlock()
+
+	out := make([]byte, headerSize+aead.NonceSize(), headerSize+aead.NonceSize()+len(plaintext)+aead.Overhead())
+	out[0] = version
+	binary.BigEndian.PutUint32(out[1:headerSize], id)
+
+	nonce := out[headerSize:]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(out, nonce, plaintext, out[:headerSize]), nil
+}
+
+// Decrypt decrypts a ciphertext with the key it has been encrypted with
+func (r *KeyRing) Decrypt(ciphertext []byte) ([]byte, error) {
+	id, err := KeyID(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	aead, ok := r.keys[id]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownKey, id)
+	}
+
+	if len(ciphertext) < headerSize+aead.NonceSize()+aead.Overhead() {
+		return nil, ErrMalformedCiphertext
+	}
+	nonce := ciphertext[headerSize : headerSize+aead.NonceSize()]
+	plaintext, err := aead.Open(nil, nonce, ciphertext[headerSize+aead.NonceSize():], ciphertext[:headerSize])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedCiphertext, err)
+	}
+	return plaintext, nil
+}
+
+// KeyID returns the ID of the key a ciphertext has been encrypted with
+func KeyID(ciphertext []byte) (uint32, error) {
+	if len(ciphertext) < headerSize || ciphertext[0] != version {
+		return 0, ErrMalformedCiphertext
+	}
+	return binary.BigEndian.Uint32(ciphertext[1:headerSize]), nil
+}
@@ -0,0 +1,362 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ErrBind is returned when the arguments can not be bound to a query
+var ErrBind = errors.New("db: can not bind arguments")
+
+var valuerType = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+
+// errArg is an argument that fails its conversion,
+// it reports an error through the *sql.Row returned by QueryRow
+type errArg struct {
+	err error
+}
+
+// Value implements driver.Valuer
+func (a errArg) Value() (driver.Value, error) {
+	return nil, a.err
+}
+
+// bind binds the named parameters, expands the slice arguments and
+// rewrites the placeholders of a query for the dialect of the database.
+//
+// Named parameters (:name) are bound when the only argument is a map with string keys
+// or a struct, whose fields are named like the columns scanned by Get and Select.
+// A slice argument is expanded to as many placeholders as its elements, for IN (...) clauses.
+// The ? placeholders are rewritten to the placeholders of the dialect when the database
+// or the context rebinds them, or when the query has named parameters: ?? is then a literal ?,
+// like in the ?| operator of PostgreSQL written ??|. A query already written with the placeholders
+// of the dialect, like $1 or @p1, is left as is.
+// The Encrypted arguments are encrypted later, once the query has been intercepted.
+func (db *DB) bind(ctx context.Context, query string, args []interface{}) (string, []interface{}, error) {
+	var err error
+	if !db.Dialect.hasPlaceholders(query) {
+		rebind := db.Dialect.rebinds() && db.rebinding(ctx)
+		if len(args) == 1 && isNamedArg(args[0]) && strings.IndexByte(query, ':') >= 0 {
+			if query, args, err = db.Dialect.named(query, args[0]); err != nil {
+				return "", nil, err
+			}
+			rebind = db.Dialect.rebinds()
+		}
+
+		// the slice arguments are expanded where ? is a placeholder
+		if rebind || !db.Dialect.rebinds() && hasSliceArg(args) {
+			if query, args, err = db.Dialect.rebind(query, args); err != nil {
+				return "", nil, err
+			}
+		}
+	}
+
+	return query, args, nil
+}
+
+// WithRebind is a database option that rewrites the ? placeholders of the queries for the dialect
+func WithRebind() func(*DB) error {
+	return func(db *DB) error {
+		db.Rebind = true
+		return nil
+	}
+}
+
+// UseRebind returns a context in which the ? placeholders of the queries are rewritten
+// for the dialect, even if the database does not rebind them
+func UseRebind(ctx context.Context) context.Context {
+	return context.WithValue(ctx, rebindKey, true)
+}
+
+// rebinding reports whether the ? placeholders of the queries are rewritten in the context
+func (db *DB) rebinding(ctx context.Context) bool {
+	if db.Rebind {
+		return true
+	}
+	rebind, _ := ctx.Value(rebindKey).(bool)
+	return rebind
+}
+
+// isNamedArg reports whether the argument binds named parameters
+func isNamedArg(arg interface{}) bool {
+	t := reflect.TypeOf(arg)
+	if t == nil || t.Implements(valuerType) {
+		return false
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Map:
+		return t.Key().Kind() == reflect.String
+	case reflect.Struct:
+		return t != timeType
+	default:
+		return false
+	}
+}
+
+// sliceArg returns the elements of a slice argument
+func sliceArg(arg interface{}) ([]interface{}, bool) {
+	t := reflect.TypeOf(arg)
+	if t == nil || t.Kind() != reflect.Slice || t.Elem().Kind() == reflect.Uint8 || t.Implements(valuerType) {
+		return nil, false
+	}
+
+	v := reflect.ValueOf(arg)
+	vs := make([]interface{}, v.Len())
+	for i := range vs {
+		vs[i] = v.Index(i).Interface()
+	}
+	return vs, true
+}
+
+func hasSliceArg(args []interface{}) bool {
+	for _, arg := range args {
+		if _, ok := sliceArg(arg); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// namedValues returns a lookup of the named parameters bound from a map or a struct
+func namedValues(arg interface{}) func(string) (interface{}, bool) {
+	v := reflect.Indirect(reflect.ValueOf(arg))
+
+	if v.Kind() == reflect.Map {
+		return func(name string) (interface{}, bool) {
+			if v.IsNil() {
+				return nil, false
+			}
+			mv := v.MapIndex(reflect.ValueOf(name).Convert(v.Type().Key()))
+			if !mv.IsValid() {
+				return nil, false
+			}
+			return mv.Interface(), true
+		}
+	}
+
+	fields := fieldsOf(v.Type())
+	return func(name string) (interface{}, bool) {
+		at, ok := fields.byName[name]
+		if !ok {
+			return nil, false
+		}
+		return fieldValue(v, fields.fields[at].index), true
+	}
+}
+
+// named rewrites the :name parameters of a query to ? placeholders bound from arg
+func (d Dialect) named(query string, arg interface{}) (string, []interface{}, error) {
+	lookup := namedValues(arg)
+
+	var b strings.Builder
+	args := make([]interface{}, 0)
+	for i := 0; i < len(query); {
+		if end := d.skipLiteral(query, i); end > i {
+			b.WriteString(query[i:end])
+			i = end
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(query[i:], "::"):
+			// type cast
+			b.WriteString("::")
+			i += 2
+		case query[i] == '?' && d.rebinds():
+			// a literal ?, like an operator, is escaped from the placeholders
+			b.WriteString("??")
+			i++
+			if i < len(query) && query[i] == '?' {
+				i++
+			}
+		case query[i] == ':' && i+1 < len(query) && isNameStart(query[i+1]):
+			j := i + 1
+			for j < len(query) && isNamePart(query[j]) {
+				j++
+			}
+
+			name := query[i+1 : j]
+			v, ok := lookup(name)
+			if !ok {
+				return "", nil, fmt.Errorf("%w: named parameter %q not found", ErrBind, name)
+			}
+			args = append(args, v)
+			b.WriteByte('?')
+			i = j
+		default:
+			b.WriteByte(query[i])
+			i++
+		}
+	}
+
+	return b.String(), args, nil
+}
+
+// rebind expands the slice arguments of a query and rewrites its ? placeholders for the dialect
+func (d Dialect) rebind(query string, args []interface{}) (string, []interface{}, error) {
+	var b strings.Builder
+	bound := make([]interface{}, 0, len(args))
+	n := 0
+	for i := 0; i < len(query); {
+		if end := d.skipLiteral(query, i); end > i {
+			b.WriteString(query[i:end])
+			i = end
+			continue
+		}
+
+		if query[i] != '?' {
+			b.WriteByte(query[i])
+			i++
+			continue
+		}
+		i++
+
+		// ?? is a literal ? where the placeholders are rewritten
+		if d.rebinds() && i < len(query) && query[i] == '?' {
+			b.WriteByte('?')
+			i++
+			continue
+		}
+
+		// more placeholders than arguments is reported by the driver
+		if n >= len(args) {
+			b.WriteString(d.placeholder(len(bound) + 1))
+			continue
+		}
+
+		arg := args[n]
+		n++
+
+		vs, ok := sliceArg(arg)
+		if !ok {
+			bound = append(bound, arg)
+			b.WriteString(d.placeholder(len(bound)))
+			continue
+		}
+		if len(vs) == 0 {
+			return "", nil, fmt.Errorf("%w: empty slice argument %d", ErrBind, n)
+		}
+		for k, v := range vs {
+			if k > 0 {
+				b.WriteString(", ")
+			}
+			bound = append(bound, v)
+			b.WriteString(d.placeholder(len(bound)))
+		}
+	}
+
+	// arguments without placeholder, like sql.NamedArg, are passed as is
+	bound = append(bound, args[n:]...)
+
+	return b.String(), bound, nil
+}
+
+// hasPlaceholders reports whether a query is written with the placeholders of the dialect, like $1 or @p1
+func (d Dialect) hasPlaceholders(query string) bool {
+	if !d.rebinds() {
+		return false
+	}
+
+	prefix := "$"
+	if d == SQLServer {
+		prefix = "@p"
+	}
+	for i := 0; i < len(query); {
+		if end := d.skipLiteral(query, i); end > i {
+			i = end
+			continue
+		}
+
+		j := i + len(prefix)
+		if strings.HasPrefix(query[i:], prefix) && j < len(query) && query[j] >= '0' && query[j] <= '9' &&
+			(i == 0 || !isNamePart(query[i-1]) && query[i-1] != '@') {
+			return true
+		}
+		i++
+	}
+	return false
+}
+
+// skipLiteral returns the end of the quoted literal or comment starting at i, or i if there is none
+func (d Dialect) skipLiteral(query string, i int) int {
+	if tag := d.dollarQuote(query, i); tag != "" {
+		if j := strings.Index(query[i+len(tag):], tag); j >= 0 {
+			return i + len(tag) + j + len(tag)
+		}
+		return len(query)
+	}
+
+	switch c := query[i]; {
+	case c == '\'' || c == '"' || c == '`':
+		escapes := d.backslashEscapes() || d.escapeString(query, i)
+		for j := i + 1; j < len(query); j++ {
+			switch {
+			case query[j] == '\\' && escapes:
+				j++
+			case query[j] == c && j+1 < len(query) && query[j+1] == c:
+				// doubled quote
+				j++
+			case query[j] == c:
+				return j + 1
+			}
+		}
+		return len(query)
+	case strings.HasPrefix(query[i:], "--"):
+		if j := strings.IndexByte(query[i:], '\n'); j >= 0 {
+			return i + j + 1
+		}
+		return len(query)
+	case strings.HasPrefix(query[i:], "/*"):
+		if j := strings.Index(query[i+2:], "*/"); j >= 0 {
+			return i + 2 + j + 2
+		}
+		return len(query)
+	}
+	return i
+}
+
+// stringQuote reports whether a quote starts a string literal rather than a quoted identifier:
+// double quotes delimit strings in MySQL, and identifiers in the other dialects
+func (d Dialect) stringQuote(c byte) bool {
+	return c == '\'' || c == '"' && d == MySQL
+}
+
+// escapeString reports whether the quote at i starts a PostgreSQL escape string, like E'it\'s'
+func (d Dialect) escapeString(query string, i int) bool {
+	return d == Postgres && i > 0 && i < len(query) && query[i] == '\'' && (query[i-1] == 'E' || query[i-1] == 'e') &&
+		(i == 1 || !isNamePart(query[i-2]))
+}
+
+// dollarQuote returns the $tag$ opening a PostgreSQL dollar-quoted string at i, or an empty string
+func (d Dialect) dollarQuote(query string, i int) string {
+	if d != Postgres || query[i] != '$' || i > 0 && isNamePart(query[i-1]) {
+		return ""
+	}
+
+	j := i + 1
+	if j < len(query) && isNameStart(query[j]) {
+		for j < len(query) && isNamePart(query[j]) {
+			j++
+		}
+	}
+	if j < len(query) && query[j] == '$' {
+		return query[i : j+1]
+	}
+	return ""
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNamePart(c byte) bool {
+	return isNameStart(c) || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,221 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBind(t *testing.T) {
+	type filter struct {
+		Name  string `db:"name"`
+		Email string
+	}
+
+	tests := []struct {
+		name    string
+		dialect Dialect
+		rebind  bool
+		query   string
+		args    []interface{}
+		want    string
+		wantArg []interface{}
+	}{
+		{
+			name:    "positional",
+			dialect: MySQL,
+			query:   "SELECT * FROM `users` WHERE `id` = ?",
+			args:    []interface{}{1},
+			want:    "SELECT * FROM `users` WHERE `id` = ?",
+			wantArg: []interface{}{1},
+		},
+		{
+			name:    "rebind",
+			dialect: Postgres,
+			rebind:  true,
+			query:   "SELECT * FROM users WHERE id = ? AND name = '?' AND email = ?",
+			args:    []interface{}{1, "email"},
+			want:    "SELECT * FROM users WHERE id = $1 AND name = '?' AND email = $2",
+			wantArg: []interface{}{1, "email"},
+		},
+		{
+			name:    "slice",
+			dialect: Postgres,
+			rebind:  true,
+			query:   "SELECT * FROM users WHERE id IN (?) AND name = ?",
+			args:    []interface{}{[]int{1, 2, 3}, "name"},
+			want:    "SELECT * FROM users WHERE id IN ($1, $2, $3) AND name = $4",
+			wantArg: []interface{}{1, 2, 3, "name"},
+		},
+		{
+			name:    "named map",
+			dialect: SQLServer,
+			query:   "SELECT * FROM users WHERE id IN (:ids) AND created_at > CAST(:since AS date)",
+			args:    []interface{}{map[string]interface{}{"ids": []int64{1, 2}, "since": "2022-01-01"}},
+			want:    "SELECT * FROM users WHERE id IN (@p1, @p2) AND created_at > CAST(@p3 AS date)",
+			wantArg: []interface{}{int64(1), int64(2), "2022-01-01"},
+		},
+		{
+			name:    "named struct",
+			dialect: Postgres,
+			query:   "SELECT * FROM users WHERE name = :name AND email = :email AND note = ':name' AND id::text <> ''",
+			args:    []interface{}{&filter{Name: "name", Email: "email"}},
+			want:    "SELECT * FROM users WHERE name = $1 AND email = $2 AND note = ':name' AND id::text <> ''",
+			wantArg: []interface{}{"name", "email"},
+		},
+		{
+			name:    "backslash escape",
+			dialect: MySQL,
+			query:   "SELECT * FROM `users` WHERE `name` <> 'it\\'s :name' AND `name` = :name",
+			args:    []interface{}{filter{Name: "name"}},
+			want:    "SELECT * FROM `users` WHERE `name` <> 'it\\'s :name' AND `name` = ?",
+			wantArg: []interface{}{"name"},
+		},
+		{
+			name:    "no rebind",
+			dialect: Postgres,
+			query:   "SELECT * FROM users WHERE data ? 'admin' AND id = $1",
+			args:    []interface{}{1},
+			want:    "SELECT * FROM users WHERE data ? 'admin' AND id = $1",
+			wantArg: []interface{}{1},
+		},
+		{
+			name:    "jsonb operator",
+			dialect: Postgres,
+			rebind:  true,
+			query:   "SELECT * FROM users WHERE data ?? 'admin' AND data ??| ? AND id = ?",
+			args:    []interface{}{"roles", 1},
+			want:    "SELECT * FROM users WHERE data ? 'admin' AND data ?| $1 AND id = $2",
+			wantArg: []interface{}{"roles", 1},
+		},
+		{
+			name:    "native placeholders",
+			dialect: Postgres,
+			rebind:  true,
+			query:   "SELECT * FROM users WHERE data ? 'admin' AND id = $1 AND name <> '$2'",
+			args:    []interface{}{1},
+			want:    "SELECT * FROM users WHERE data ? 'admin' AND id = $1 AND name <> '$2'",
+			wantArg: []interface{}{1},
+		},
+		{
+			name:    "native placeholders sqlserver",
+			dialect: SQLServer,
+			rebind:  true,
+			query:   "SELECT * FROM users WHERE id = @p1 AND name = ?",
+			args:    []interface{}{1},
+			want:    "SELECT * FROM users WHERE id = @p1 AND name = ?",
+			wantArg: []interface{}{1},
+		},
+		{
+			name:    "named jsonb operator",
+			dialect: Postgres,
+			query:   "SELECT * FROM users WHERE data ? :role AND data ??& :roles",
+			args:    []interface{}{map[string]interface{}{"role": "admin", "roles": "{a,b}"}},
+			want:    "SELECT * FROM users WHERE data ? $1 AND data ?& $2",
+			wantArg: []interface{}{"admin", "{a,b}"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &DB{Dialect: tt.dialect, Rebind: tt.rebind}
+			query, args, err := db.bind(context.Background(), tt.query, tt.args)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, query)
+			assert.Equal(t, tt.wantArg, args)
+		})
+	}
+}
+
+func TestBindError(t *testing.T) {
+	db := &DB{Dialect: Postgres, Rebind: true}
+	ctx := context.Background()
+
+	_, _, err := db.bind(ctx, "SELECT * FROM users WHERE id IN (?)", []interface{}{[]int{}})
+	assert.ErrorIs(t, err, ErrBind)
+
+	_, _, err = db.bind(ctx, "SELECT * FROM users WHERE id = :id", []interface{}{map[string]interface{}{}})
+	assert.ErrorIs(t, err, ErrBind)
+}
+
+func TestUseRebind(t *testing.T) {
+	db := &DB{Dialect: Postgres}
+	query := "SELECT * FROM users WHERE id = ?"
+
+	bound, _, err := db.bind(context.Background(), query, []interface{}{1})
+	assert.NoError(t, err)
+	assert.Equal(t, query, bound)
+
+	bound, _, err = db.bind(UseRebind(context.Background()), query, []interface{}{1})
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM users WHERE id = $1", bound)
+}
+
+// TestQueryRowBindError will test the bind error reported through the returned row.
+func (s *DBTestSuite) TestQueryRowBindError() {
+	ctx := context.Background()
+
+	q := "SELECT `name` FROM `users` WHERE `id` = :id"
+
+	// test
+	var name string
+	err := s.db.QueryRow(ctx, q, map[string]interface{}{}).Scan(&name)
+	assert.ErrorIs(s.T(), err, ErrBind)
+
+	err = s.db.QueryRowx(ctx, q, map[string]interface{}{}).Scan(&name)
+	assert.ErrorIs(s.T(), err, ErrBind)
+	assert.NotContains(s.T(), err.Error(), "converting argument")
+
+	assert.Zero(s.T(), s.db.DB.Stats().InUse)
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
+
+// TestExecNamed will test the named parameters bound from a struct.
+func (s *DBTestSuite) TestExecNamed() {
+	ctx := context.Background()
+
+	// mock
+	q := "INSERT INTO `users` (`name`, `email`) VALUES (?, ?)"
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(q).WithArgs("name", "email").WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectCommit()
+
+	// test
+	u := user{Name: "name"}
+	u.Email.String, u.Email.Valid = "email", true
+	_, err := s.db.Exec(ctx, "INSERT INTO `users` (`name`, `email`) VALUES (:name, :email)", u)
+
+	assert.NoError(s.T(), err)
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
+
+func TestSanitize(t *testing.T) {
+	assert.Equal(t,
+		"SELECT * FROM users WHERE id = $1 AND name = ? AND age > ? AND t2.id = ? -- 'kept'",
+		Postgres.sanitize("SELECT * FROM users WHERE id = $1 AND name = 'it''s' AND age > 20.5 AND t2.id = 3 -- 'kept'"),
+	)
+
+	// double quotes delimit strings in MySQL, and identifiers elsewhere
+	assert.Equal(t,
+		"SELECT `email` FROM `users` WHERE email = ? AND name = ?",
+		MySQL.sanitize("SELECT `email` FROM `users` WHERE email = \"alice@example.com\" AND name = 'it\\'s'"),
+	)
+	assert.Equal(t,
+		`SELECT "email" FROM "users" WHERE email = ?`,
+		Postgres.sanitize(`SELECT "email" FROM "users" WHERE email = 'alice@example.com'`),
+	)
+
+	// dollar-quoted and escape strings of PostgreSQL
+	assert.Equal(t,
+		"SELECT ?, ?, ? FROM users WHERE id = $1",
+		Postgres.sanitize("SELECT $$alice's secret$$, $tag$it's $$ nested$tag$, E'it\\'s' FROM users WHERE id = $1"),
+	)
+}
+
+func TestBindDollarQuote(t *testing.T) {
+	query, _, err := Postgres.rebind("SELECT $$is it?$$ FROM users WHERE id = ?", []interface{}{1})
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT $$is it?$$ FROM users WHERE id = $1", query)
+}
@@ -0,0 +1,357 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/org39/gopkg/log"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Cache stores the results of queries by key, invalidated by tags.
+// A Cache is safe for concurrent use.
+type Cache interface {
+	// Get returns the value of a key, false if it is missing or expired
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set stores the value of a key for a duration, with the tags invalidating it
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration, tags []string) error
+	// Invalidate removes the values of the tags
+	Invalidate(ctx context.Context, tags ...string) error
+}
+
+// WithCache is a database option that sets the cache of the query results
+func WithCache(c Cache) func(*DB) error {
+	return func(db *DB) error {
+		db.Cache = c
+		return nil
+	}
+}
+
+// dbCacheHitKey reports whether the result of a query has been read from the cache
+var dbCacheHitKey = attribute.Key("db.cache_hit")
+
+// cacheOptions are the options of a cached query
+type cacheOptions struct {
+	ttl  time.Duration
+	tags []string
+}
+
+// UseCache returns a context in which the results of QueryRowx, Get, Select and Stream are read
+// through the cache of the database, and kept for the given duration. The results are invalidated
+// by the tags, and by the statements of Exec on the tables named by the tags.
+// Within a transaction, or without a cache, queries are executed as is.
+func UseCache(ctx context.Context, ttl time.Duration, tags ...string) context.Context {
+	return context.WithValue(ctx, cacheKey, cacheOptions{ttl: ttl, tags: tags})
+}
+
+// withoutCache returns a context in which the queries are not read through the cache
+func withoutCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cacheKey, nil)
+}
+
+// cacheOptions returns the options of a query read through the cache, if any
+func (db *DB) cacheOptions(ctx context.Context) (cacheOptions, bool) {
+	if db.Cache == nil || InTransaction(ctx) {
+		return cacheOptions{}, false
+	}
+	o, ok := ctx.Value(cacheKey).(cacheOptions)
+	return o, ok
+}
+
+// cachedQuery executes a bound query through the cache. Like the queries of the database,
+// it is seen by the interceptors, traced and logged if slow, its key being computed once
+// the interceptors have rewritten it.
+func (db *DB) cachedQuery(ctx context.Context, kind OpKind, o cacheOptions, query string, args []interface{}) (*cachedResult, error) {
+	op := &Op{Kind: kind, Query: query, Args: args}
+	ctx, after, err := db.intercept(ctx, op)
+	if err != nil {
+		return nil, err
+	}
+	query, args = op.Query, op.Args
+
+	name := spanQuery
+	if kind == OpQueryRow {
+		name = spanQueryRow
+	}
+	ctx, span := db.startSpan(ctx, name, query)
+	start := time.Now()
+
+	res, hit, err := db.readThrough(ctx, o, query, args)
+
+	span.SetAttributes(dbCacheHitKey.Bool(hit))
+	db.logSlowQuery(ctx, query, args, time.Since(start))
+	endSpan(span, err)
+	after(err)
+	return res, err
+}
+
+// readThrough returns the result of a query from the cache, or executes the query and caches its result.
+// Concurrent misses of the same query execute it once, it reports whether the result was cached.
+func (db *DB) readThrough(ctx context.Context, o cacheOptions, query string, args []interface{}) (*cachedResult, bool, error) {
+	key := db.resultKey(query, args)
+
+	// a failing cache is bypassed
+	value, ok, err := db.Cache.Get(ctx, key)
+	if err != nil {
+		log.LoggerWithSpan(ctx).WithError(err).Warn("db: can not read the cache")
+	}
+	if ok {
+		if res, err := decodeResult(value); err == nil {
+			return res, true, nil
+		}
+	}
+
+	res, err := db.flights.do(ctx, key, func(ctx context.Context) (*cachedResult, error) {
+		gen := db.tagGens.current(o.tags)
+		args, err := db.encryptArgs(args)
+		if err != nil {
+			return nil, err
+		}
+
+		q, release := db.querier(ctx, query, false)
+		rows, err := q.QueryContext(ctx, query, args...)
+		release()
+		if err != nil {
+			return nil, err
+		}
+		res, err := collectResult(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		// a result read while its tags are invalidated may be stale, it is not written back,
+		// and is invalidated again if the tags have been invalidated while it was written
+		if db.tagGens.current(o.tags) != gen {
+			return res, nil
+		}
+		value, err := encodeResult(res)
+		if err == nil {
+			err = db.Cache.Set(ctx, key, value, o.ttl, o.tags)
+		}
+		if err == nil && db.tagGens.current(o.tags) != gen {
+			err = db.Cache.Invalidate(ctx, o.tags...)
+		}
+		if err != nil {
+			log.LoggerWithSpan(ctx).WithError(err).Warn("db: can not write the cache")
+		}
+		return res, nil
+	})
+	return res, false, err
+}
+
+// resultKey returns the cache key of the result of a query, from the values of its arguments
+// sent to the driver: a pointer is keyed by the value it points at, a time by its instant and zone,
+// and an Encrypted argument by its plaintext, as its ciphertext changes with every encryption
+func (db *DB) resultKey(query string, args []interface{}) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s", db.Name, query)
+	for _, arg := range args {
+		if named, ok := arg.(sql.NamedArg); ok {
+			fmt.Fprintf(h, "\x00@%s", named.Name)
+			arg = named.Value
+		}
+
+		v := keyValue(arg)
+		if t, ok := v.(time.Time); ok {
+			v = t.Format(time.RFC3339Nano)
+		}
+		fmt.Fprintf(h, "\x00%T:%v", v, v)
+	}
+	return "db:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// keyValue returns the value of an argument keying the result of a query
+func keyValue(arg interface{}) interface{} {
+	switch e := arg.(type) {
+	case Encrypted:
+		return NewNull(e)
+	case *Encrypted:
+		if e == nil {
+			return Null[Encrypted]{}
+		}
+		return NewNull(*e)
+	case Null[Encrypted]:
+		return e
+	case *Null[Encrypted]:
+		if e == nil {
+			return Null[Encrypted]{}
+		}
+		return *e
+	}
+
+	// an argument the driver converts by itself is keyed as is
+	if v, err := driver.DefaultParameterConverter.ConvertValue(arg); err == nil {
+		return v
+	}
+	return arg
+}
+
+// Invalidate invalidates the cached results of the tags. Within a transaction,
+// they are invalidated once it has committed.
+func (db *DB) Invalidate(ctx context.Context, tags ...string) error {
+	if db.Cache == nil || len(tags) == 0 {
+		return nil
+	}
+
+	if InTransaction(ctx) {
+		AfterCommit(ctx, func(ctx context.Context) {
+			db.tagGens.invalidate(tags)
+			if err := db.Cache.Invalidate(ctx, tags...); err != nil {
+				log.LoggerWithSpan(ctx).WithError(err).Warn("db: can not invalidate the cache")
+			}
+		})
+		return nil
+	}
+	db.tagGens.invalidate(tags)
+	return db.Cache.Invalidate(ctx, tags...)
+}
+
+// statementTable matches the table written by a statement
+var statementTable = regexp.MustCompile(
+	`(?i)^\s*(?:INSERT\s+(?:IGNORE\s+)?INTO|REPLACE\s+INTO|UPDATE|DELETE\s+FROM|TRUNCATE(?:\s+TABLE)?|MERGE\s+INTO)\s+([^\s(,;]+)`)
+
+// writtenTable returns the name of the table written by a statement, lowercased and unquoted,
+// or an empty string if it is unknown
+func writtenTable(query string) string {
+	m := statementTable.FindStringSubmatch(query)
+	if m == nil {
+		return ""
+	}
+
+	name := m[1]
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		name = name[i+1:]
+	}
+	return strings.ToLower(strings.Trim(name, "`\"[]"))
+}
+
+// invalidateWritten invalidates the cached results of the table written by a statement
+func (db *DB) invalidateWritten(ctx context.Context, query string) {
+	if db.Cache == nil {
+		return
+	}
+	if table := writtenTable(query); table != "" {
+		if err := db.Invalidate(ctx, table); err != nil {
+			log.LoggerWithSpan(ctx).WithError(err).Warn("db: can not invalidate the cache")
+		}
+	}
+}
+
+// generations counts the invalidations of the tags, so that the results read while their tags
+// are invalidated are not written back into the cache
+type generations struct {
+	mu   sync.Mutex
+	gens map[string]uint64
+}
+
+// current returns the generation of the tags, which changes whenever one of them is invalidated
+func (g *generations) current(tags []string) uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var gen uint64
+	for _, tag := range tags {
+		gen += g.gens[tag]
+	}
+	return gen
+}
+
+// invalidate starts a new generation of the tags
+func (g *generations) invalidate(tags []string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.gens == nil {
+		g.gens = make(map[string]uint64)
+	}
+	for _, tag := range tags {
+		g.gens[tag]++
+	}
+}
+
+// flight is a query in flight, shared by concurrent misses
+type flight struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int
+	res     *cachedResult
+	err     error
+}
+
+// flightGroup collapses the concurrent misses of the same key
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// do executes the function once for the concurrent calls of a key. The function runs with the values
+// of the context of the first call, but is only canceled once every call has stopped waiting for it.
+func (g *flightGroup) do(ctx context.Context, key string, fn func(context.Context) (*cachedResult, error)) (*cachedResult, error) {
+	g.mu.Lock()
+	f, ok := g.flights[key]
+	if !ok {
+		if g.flights == nil {
+			g.flights = make(map[string]*flight)
+		}
+		fctx, cancel := context.WithCancel(detachedContext{ctx})
+		f = &flight{done: make(chan struct{}), cancel: cancel}
+		g.flights[key] = f
+		go g.run(fctx, key, f, fn)
+	}
+	f.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.res, f.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		if f.waiters--; f.waiters == 0 {
+			f.cancel()
+			g.forget(key, f)
+		}
+		g.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// run executes the function of a flight, and releases its waiters
+func (g *flightGroup) run(ctx context.Context, key string, f *flight, fn func(context.Context) (*cachedResult, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			f.err = fmt.Errorf("db: panic while reading the cache: %v", r)
+		}
+		g.mu.Lock()
+		g.forget(key, f)
+		g.mu.Unlock()
+		f.cancel()
+		close(f.done)
+	}()
+
+	f.res, f.err = fn(ctx)
+}
+
+// forget removes a flight, unless it has already been replaced by a new one
+func (g *flightGroup) forget(key string, f *flight) {
+	if g.flights[key] == f {
+		delete(g.flights, key)
+	}
+}
+
+// detachedContext keeps the values of a context, without its deadline and cancelation
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
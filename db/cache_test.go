@@ -0,0 +1,334 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCache will test the queries read through the cache, and invalidated by the statements.
+func (s *DBTestSuite) TestCache() {
+	ctx := context.Background()
+	s.db.Cache = NewMemoryCache(0)
+	cached := UseCache(ctx, time.Minute, "users")
+
+	// mock
+	q := "SELECT `id`, `name` FROM `users` WHERE `id` = ?"
+	u := "UPDATE `users` SET `name` = ? WHERE `id` = ?"
+	s.mock.ExpectQuery(q).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "alice"))
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(u).WithArgs("bob", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectRollback()
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(u).WithArgs("bob", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+	s.mock.ExpectQuery(q).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "bob"))
+
+	// test
+	name := func() string {
+		var id int
+		var name string
+		assert.NoError(s.T(), s.db.QueryRowx(cached, q, 1).Scan(&id, &name))
+		assert.Equal(s.T(), 1, id)
+		return name
+	}
+
+	// miss, then hit
+	assert.Equal(s.T(), "alice", name())
+	assert.Equal(s.T(), "alice", name())
+	names, err := Select[struct {
+		ID   int    `db:"id"`
+		Name string `db:"name"`
+	}](cached, s.db, q, 1)
+	assert.NoError(s.T(), err)
+	assert.Len(s.T(), names, 1)
+
+	// a rolled back statement does not invalidate
+	err = s.db.WithTransaction(ctx, func(txCtx context.Context) error {
+		if _, err := s.db.Exec(txCtx, u, "bob", 1); err != nil {
+			return err
+		}
+		return errors.New("rollback")
+	})
+	assert.EqualError(s.T(), err, "rollback")
+	assert.Equal(s.T(), "alice", name())
+
+	// a committed statement invalidates the results of its table
+	_, err = s.db.Exec(ctx, u, "bob", 1)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "bob", name())
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
+
+// TestCacheInterceptors will test the cached queries seen by the interceptors, and cached once rewritten.
+func (s *DBTestSuite) TestCacheInterceptors() {
+	type tenantKey struct{}
+	ctx := UseCache(context.Background(), time.Minute, "users")
+	s.db.Cache = NewMemoryCache(0)
+	var kinds []OpKind
+	s.db.Interceptors = []Interceptor{InterceptorFuncs{
+		BeforeFunc: func(ctx context.Context, op *Op) (context.Context, error) {
+			kinds = append(kinds, op.Kind)
+			op.Query += " AND `tenant_id` = ?"
+			op.Args = append(op.Args, ctx.Value(tenantKey{}))
+			return ctx, nil
+		},
+	}}
+
+	// mock
+	q := "SELECT `name` FROM `users` WHERE `id` = ?"
+	s.mock.ExpectQuery(q+" AND `tenant_id` = ?").WithArgs(1, "a").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("alice"))
+	s.mock.ExpectQuery(q+" AND `tenant_id` = ?").WithArgs(1, "b").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}))
+
+	// test
+	a := context.WithValue(ctx, tenantKey{}, "a")
+	b := context.WithValue(ctx, tenantKey{}, "b")
+	for i := 0; i < 2; i++ {
+		var name string
+		assert.NoError(s.T(), s.db.QueryRowx(a, q, 1).Scan(&name))
+		assert.Equal(s.T(), "alice", name)
+		assert.ErrorIs(s.T(), s.db.QueryRowx(b, q, 1).Scan(&name), sql.ErrNoRows)
+	}
+	names, err := Select[string](a, s.db, q, 1)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), []string{"alice"}, names)
+
+	assert.Equal(s.T(), []OpKind{OpQueryRow, OpQueryRow, OpQueryRow, OpQueryRow, OpQuery}, kinds)
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
+
+// TestCacheCanceledMiss will test a miss shared by several callers not failed by the first one.
+func (s *DBTestSuite) TestCacheCanceledMiss() {
+	ctx := UseCache(context.Background(), time.Minute)
+	s.db.Cache = NewMemoryCache(0)
+
+	// mock
+	q := "SELECT `name` FROM `users` WHERE `id` = ?"
+	s.mock.ExpectQuery(q).WithArgs(1).WillDelayFor(100 * time.Millisecond).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("alice"))
+
+	// test
+	first, cancel := context.WithCancel(ctx)
+	done := make(chan error)
+	go func() {
+		var name string
+		done <- s.db.QueryRowx(first, q, 1).Scan(&name)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+
+	var name string
+	assert.NoError(s.T(), s.db.QueryRowx(ctx, q, 1).Scan(&name))
+	assert.Equal(s.T(), "alice", name)
+	assert.ErrorIs(s.T(), <-done, context.Canceled)
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
+
+// TestCacheInvalidatedMiss will test a miss racing an invalidation not written back.
+func (s *DBTestSuite) TestCacheInvalidatedMiss() {
+	ctx := UseCache(context.Background(), time.Minute, "users")
+	s.db.Cache = NewMemoryCache(0)
+
+	// mock
+	q := "SELECT `name` FROM `users` WHERE `id` = ?"
+	s.mock.ExpectQuery(q).WithArgs(1).WillDelayFor(50 * time.Millisecond).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("alice"))
+	s.mock.ExpectQuery(q).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("bob"))
+
+	// test
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		assert.NoError(s.T(), s.db.Invalidate(context.Background(), "users"))
+	}()
+	var name string
+	assert.NoError(s.T(), s.db.QueryRowx(ctx, q, 1).Scan(&name))
+	assert.Equal(s.T(), "alice", name)
+	assert.NoError(s.T(), s.db.QueryRowx(ctx, q, 1).Scan(&name))
+	assert.Equal(s.T(), "bob", name)
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
+
+// TestCacheBypass will test the queries of a transaction executed without the cache.
+func (s *DBTestSuite) TestCacheBypass() {
+	ctx := context.Background()
+	s.db.Cache = NewMemoryCache(0)
+
+	// mock
+	q := "SELECT `name` FROM `users` WHERE `id` = ?"
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery(q).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("alice"))
+	s.mock.ExpectQuery(q).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("alice"))
+	s.mock.ExpectCommit()
+
+	// test
+	err := s.db.WithTransaction(UseCache(ctx, time.Minute), func(txCtx context.Context) error {
+		for i := 0; i < 2; i++ {
+			var name string
+			if err := s.db.QueryRowx(txCtx, q, 1).Scan(&name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.NoError(s.T(), err)
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
+
+// TestCacheConcurrentMisses will test the concurrent misses of a query executing it once.
+func (s *DBTestSuite) TestCacheConcurrentMisses() {
+	ctx := UseCache(context.Background(), time.Minute)
+	s.db.Cache = NewMemoryCache(0)
+
+	// mock
+	q := "SELECT `name` FROM `users`"
+	s.mock.ExpectQuery(q).WillDelayFor(50 * time.Millisecond).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("alice").AddRow("bob"))
+
+	// test
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			names, err := Select[string](ctx, s.db, q)
+			assert.NoError(s.T(), err)
+			assert.Equal(s.T(), []string{"alice", "bob"}, names)
+		}()
+	}
+	wg.Wait()
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
+
+// TestCacheKeyArgs will test the results keyed by the values of their arguments sent to the driver.
+func (s *DBTestSuite) TestCacheKeyArgs() {
+	ctx := context.Background()
+	s.db.Cache = NewMemoryCache(0)
+	s.db.KeyRing = newTestKeyRing(s.T())
+	cached := UseCache(ctx, time.Minute, "users")
+
+	// mock
+	byID := "SELECT `name` FROM `users` WHERE `id` = ?"
+	bySince := "SELECT `name` FROM `users` WHERE `created_at` > ?"
+	byPhone := "SELECT `name` FROM `users` WHERE `phone` = ?"
+	s.mock.ExpectQuery(byID).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("alice"))
+	s.mock.ExpectQuery(bySince).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("alice"))
+	s.mock.ExpectQuery(byPhone).WithArgs(ciphertextOf(1)).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("alice"))
+
+	// test
+	hit := func(query string, first, second interface{}) {
+		var name string
+		assert.NoError(s.T(), s.db.QueryRowx(cached, query, first).Scan(&name))
+		assert.NoError(s.T(), s.db.QueryRowx(cached, query, second).Scan(&name))
+		assert.Equal(s.T(), "alice", name)
+	}
+
+	// a pointer by the value it points at
+	id, other := 1, 1
+	hit(byID, &id, &other)
+
+	// a time without its monotonic clock reading
+	now := time.Now()
+	hit(bySince, now, now.Round(0))
+
+	// an encrypted value by its plaintext
+	hit(byPhone, Encrypted("+33 6 12 34 56 78"), Encrypted("+33 6 12 34 56 78"))
+
+	assert.Equal(s.T(), 3, s.db.Cache.(*MemoryCache).Len())
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
+
+func TestCachedRows(t *testing.T) {
+	now := time.Date(2022, 5, 1, 12, 30, 0, 0, time.UTC)
+	rows := newCachedRows(&cachedResult{
+		Columns: []string{"id", "name", "email", "avatar", "created_at"},
+		Rows:    [][]interface{}{{int64(1), []byte("alice"), nil, []byte{0xca, 0xfe}, now}},
+	})
+
+	var id int
+	var name string
+	var email *string
+	var avatar []byte
+	var createdAt Null[time.Time]
+	columns, err := rows.Columns()
+	assert.NoError(t, err)
+	assert.Len(t, columns, 5)
+	assert.True(t, rows.Next())
+	assert.NoError(t, rows.Scan(&id, &name, &email, &avatar, &createdAt))
+	assert.Equal(t, 1, id)
+	assert.Equal(t, "alice", name)
+	assert.Nil(t, email)
+	assert.Equal(t, []byte{0xca, 0xfe}, avatar)
+	assert.Equal(t, now, createdAt.V)
+
+	// the cached bytes are copied
+	avatar[0] = 0
+	assert.Equal(t, []byte{0xca, 0xfe}, rows.res.Rows[0][3])
+
+	assert.EqualError(t, rows.Scan(&id), "db: expected 5 destination arguments in Scan, not 1")
+	assert.Error(t, rows.Scan(&name, &id, &id, &avatar, &createdAt))
+	assert.False(t, rows.Next())
+	assert.NoError(t, rows.Err())
+}
+
+func TestWrittenTable(t *testing.T) {
+	cases := map[string]string{
+		"INSERT INTO `users` (`name`) VALUES (?)":    "users",
+		"insert ignore into users(name) values (?)":  "users",
+		`UPDATE "public"."Users" SET name = $1`:      "users",
+		"DELETE FROM [dbo].[users] WHERE id = @p1":   "users",
+		"  REPLACE INTO users VALUES (?)":            "users",
+		"TRUNCATE TABLE users":                       "users",
+		"SELECT * FROM users":                        "",
+		"CREATE TABLE users (id INT)":                "",
+		"WITH x AS (SELECT 1) DELETE FROM users":     "",
+		"MERGE INTO users USING staged ON (id = id)": "users",
+	}
+	for query, table := range cases {
+		assert.Equal(t, table, writtenTable(query), query)
+	}
+}
+
+func TestMemoryCache(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemoryCache(2)
+
+	assert.NoError(t, c.Set(ctx, "a", []byte("1"), time.Minute, []string{"users"}))
+	assert.NoError(t, c.Set(ctx, "b", []byte("2"), time.Nanosecond, []string{"users", "orders"}))
+	time.Sleep(time.Millisecond)
+
+	// the expired values are evicted first
+	assert.NoError(t, c.Set(ctx, "c", []byte("3"), time.Minute, []string{"orders"}))
+	assert.Equal(t, 2, c.Len())
+	_, ok, err := c.Get(ctx, "b")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	value, ok, err := c.Get(ctx, "a")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("1"), value)
+
+	// then any value
+	assert.NoError(t, c.Set(ctx, "d", []byte("4"), time.Minute, nil))
+	assert.Equal(t, 2, c.Len())
+
+	// tags
+	assert.NoError(t, c.Set(ctx, "a", []byte("1"), time.Minute, []string{"users"}))
+	assert.NoError(t, c.Invalidate(ctx, "users"))
+	_, ok, _ = c.Get(ctx, "a")
+	assert.False(t, ok)
+	assert.Empty(t, c.tags["users"])
+}
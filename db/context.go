@@ -5,3 +5,11 @@ type contextKey struct {
 }
 
 var txKey = contextKey{name: "tx"}
+
+var primaryKey = contextKey{name: "primary"}
+
+var autocommitKey = contextKey{name: "autocommit"}
+
+var cacheKey = contextKey{name: "cache"}
+
+var rebindKey = contextKey{name: "rebind"}
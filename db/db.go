@@ -7,6 +7,10 @@ import (
 	"errors"
 	"fmt"
 	"time"
+
+	"github.com/org39/gopkg/crypt"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // DB is the management interface for the standard database handle
@@ -22,6 +26,57 @@ type DB struct {
 	ConnMaxLifetime time.Duration
 	// ConnMaxIdelTime is the maximum lifetime of an idle connection.
 	ConnMaxIdelTime time.Duration
+
+	// Name is the name of the database, which labels its spans and metrics.
+	Name string
+	// Dialect is the SQL dialect of the database.
+	Dialect Dialect
+	// Rebind rewrites the ? placeholders of the queries for the dialect.
+	Rebind bool
+
+	// RetryPolicy is the retry policy of WithRetryTransaction.
+	RetryPolicy RetryPolicy
+
+	// Replicas are the connectors of the read replicas.
+	Replicas []driver.Connector
+	// ReplicaPolicy decides which healthy replica serves a read.
+	ReplicaPolicy ReplicaPolicy
+	// ReplicaHealthCheckInterval is the interval between two pings of the replicas.
+	ReplicaHealthCheckInterval time.Duration
+
+	// TracerProvider is the provider of the database spans.
+	TracerProvider trace.TracerProvider
+	// MeterProvider is the provider of the connection pool metrics, nil disables them.
+	MeterProvider metric.MeterProvider
+
+	// Autocommit executes the statements of Exec without a transaction,
+	// unless one is started in the context.
+	Autocommit bool
+
+	// Interceptors observe and alter the operations of the database.
+	Interceptors []Interceptor
+
+	// Cache stores the results of the queries read through it with UseCache, nil disables it.
+	Cache Cache
+	// KeyRing encrypts and decrypts the Encrypted columns, nil disables them.
+	KeyRing *crypt.KeyRing
+
+	// StmtCacheSize is the number of prepared statements cached per connection pool, 0 disables it.
+	StmtCacheSize int
+
+	// SlowQueryThreshold is the duration above which a statement is logged, 0 disables it.
+	SlowQueryThreshold time.Duration
+	// SlowTransactionThreshold is the duration above which a transaction is logged, 0 disables it.
+	SlowTransactionThreshold time.Duration
+	// LongTransactionThreshold is the duration after which a still open transaction
+	// is logged with the stack of its caller, 0 disables it.
+	LongTransactionThreshold time.Duration
+
+	replicas   *replicaSet
+	stmtCaches map[*sql.DB]*stmtCache
+	registry   txRegistry
+	flights    flightGroup
+	tagGens    generations
 }
 
 // New creates a new database object
@@ -35,6 +90,12 @@ func New(connector driver.Connector, options ...func(*DB) error) (*DB, error) {
 		ConnMaxLifetime: 0,
 		// idle connection is closed after 5min by default
 		ConnMaxIdelTime: 5 * time.Minute,
+		// retry a transaction 5 times by default
+		RetryPolicy: DefaultRetryPolicy(),
+		// replicas are picked in turn by default
+		ReplicaPolicy: RoundRobin,
+		// replicas are pinged every 5sec by default
+		ReplicaHealthCheckInterval: 5 * time.Second,
 	}
 
 	for _, option := range options {
@@ -53,6 +114,40 @@ func New(connector driver.Connector, options ...func(*DB) error) (*DB, error) {
 	db.DB.SetConnMaxLifetime(db.ConnMaxLifetime)
 	db.DB.SetConnMaxIdleTime(db.ConnMaxIdelTime)
 
+	// read replicas share the connection pool options
+	if len(db.Replicas) > 0 {
+		dbs := make([]*sql.DB, 0, len(db.Replicas))
+		for _, connector := range db.Replicas {
+			r := sql.OpenDB(connector)
+			r.SetMaxOpenConns(db.MaxOpenConns)
+			r.SetMaxIdleConns(db.MaxIdelConns)
+			r.SetConnMaxLifetime(db.ConnMaxLifetime)
+			r.SetConnMaxIdleTime(db.ConnMaxIdelTime)
+			dbs = append(dbs, r)
+		}
+
+		db.replicas = newReplicaSet(dbs, db.ReplicaPolicy)
+		db.replicas.healthCheck(db.ReplicaHealthCheckInterval)
+	}
+
+	// prepared statement caches
+	if db.StmtCacheSize > 0 {
+		db.stmtCaches = map[*sql.DB]*stmtCache{db.DB: newStmtCache(db.DB, db.StmtCacheSize)}
+		if db.replicas != nil {
+			for _, r := range db.replicas.replicas {
+				db.stmtCaches[r.db] = newStmtCache(r.db, db.StmtCacheSize)
+			}
+		}
+	}
+
+	// connection pool metrics
+	if db.MeterProvider != nil {
+		if err := db.registerMetrics(); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
 	return db, nil
 }
 
@@ -88,129 +183,350 @@ func WithConnMaxIdleTime(d time.Duration) func(*DB) error {
 	}
 }
 
+// WithDialect is a database option that sets the SQL dialect of the database
+func WithDialect(dialect Dialect) func(*DB) error {
+	return func(db *DB) error {
+		db.Dialect = dialect
+		return nil
+	}
+}
+
+// WithAutocommit is a database option that executes the statements of Exec without a transaction
+func WithAutocommit() func(*DB) error {
+	return func(db *DB) error {
+		db.Autocommit = true
+		return nil
+	}
+}
+
+// WithReplicas is a database option that adds read replicas.
+// Reads outside a transaction are served by a healthy replica,
+// writes and transactions stay on the primary.
+func WithReplicas(connectors ...driver.Connector) func(*DB) error {
+	return func(db *DB) error {
+		db.Replicas = append(db.Replicas, connectors...)
+		return nil
+	}
+}
+
+// WithReplicaPolicy is a database option that sets how a healthy replica is picked
+func WithReplicaPolicy(policy ReplicaPolicy) func(*DB) error {
+	return func(db *DB) error {
+		db.ReplicaPolicy = policy
+		return nil
+	}
+}
+
+// WithReplicaHealthCheckInterval is a database option that sets the interval between two pings of the replicas
+func WithReplicaHealthCheckInterval(d time.Duration) func(*DB) error {
+	return func(db *DB) error {
+		if d <= 0 {
+			return fmt.Errorf("db: invalid health check interval %s", d)
+		}
+		db.ReplicaHealthCheckInterval = d
+		return nil
+	}
+}
+
 // Ping checks if the database is reachable
 func (db *DB) Ping() error {
 	return db.DB.Ping()
 }
 
-// Close closes the database connection
+// Close closes the database connection, without waiting for the transactions in flight
 func (db *DB) Close() {
-	db.DB.Close()
+	db.close()
 }
 
 // QueryRow executes a query that is expected to return at most one row.
+// The arguments are bound like Query, and the errors of the arguments and the interceptors
+// are reported by the row, wrapped by database/sql.
+// Like Query, QueryRow always reads the database and its row does not decrypt the Encrypted columns,
+// QueryRowx reads the results cached with UseCache and decrypts them.
 func (db *DB) QueryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
-	// if transaction is already started then use it
-	if tx, ok := ctx.Value(txKey).(*sql.Tx); ok {
-		return tx.QueryRowContext(ctx, query, args...)
+	query, args, err := db.bind(ctx, query, args)
+	if err == nil {
+		var row *sql.Row
+		if row, err = db.queryRow(ctx, query, args); err == nil {
+			return row
+		}
 	}
 
+	// report the error through the returned row
+	return db.DB.QueryRowContext(ctx, "", errArg{err})
+}
+
+// QueryRowx executes a query that is expected to return at most one row, like QueryRow.
+// Its row reads the results cached with UseCache, decrypts the Encrypted columns,
+// and reports the errors of the arguments and the interceptors as is, without querying the database.
+func (db *DB) QueryRowx(ctx context.Context, query string, args ...interface{}) *Row {
+	query, args, err := db.bind(ctx, query, args)
+	if err != nil {
+		return &Row{err: err}
+	}
+	if o, ok := db.cacheOptions(ctx); ok {
+		res, err := db.cachedQuery(ctx, OpQueryRow, o, query, args)
+		return &Row{res: res, keys: db.KeyRing, err: err}
+	}
+
+	row, err := db.queryRow(ctx, query, args)
+	if err != nil {
+		return &Row{err: err}
+	}
+	return &Row{row: row, keys: db.KeyRing}
+}
+
+// queryRow executes a bound query that is expected to return at most one row,
+// it returns the error of the interceptors and of the encryption of the arguments
+func (db *DB) queryRow(ctx context.Context, query string, args []interface{}) (*sql.Row, error) {
+	op := &Op{Kind: OpQueryRow, Query: query, Args: args}
+	ctx, after, err := db.intercept(ctx, op)
+	if err != nil {
+		return nil, err
+	}
+	query, args = op.Query, op.Args
+	if args, err = db.encryptArgs(args); err != nil {
+		after(err)
+		return nil, err
+	}
+
+	ctx, span := db.startSpan(ctx, spanQueryRow, query)
+	start := time.Now()
+
+	// use the transaction if it is already started,
 	// otherwise query row without transaction
-	r := db.DB.QueryRowContext(ctx, query, args...)
-	return r
+	q, release := db.querier(ctx, query, false)
+	row := q.QueryRowContext(ctx, query, args...)
+	release()
+
+	db.logSlowQuery(ctx, query, args, time.Since(start))
+	endSpan(span, row.Err())
+	after(row.Err())
+	return row, nil
 }
 
 // Query executes a query that is expected to return rows.
+// A single map or struct argument binds the named parameters (:name) of the query,
+// a slice argument is expanded for IN (...) clauses, and the ? placeholders are
+// rewritten for the dialect of the database with WithRebind or UseRebind,
+// a query written with the placeholders of the dialect is left as is.
+// Query always reads the database, the results cached with UseCache are read by QueryRowx,
+// Get, Select and Stream.
 func (db *DB) Query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
-	// if transaction is already started then use it
-	if tx, ok := ctx.Value(txKey).(*sql.Tx); ok {
-		return tx.QueryContext(ctx, query, args...)
+	query, args, err := db.bind(ctx, query, args)
+	if err != nil {
+		return nil, err
 	}
+	return db.query(ctx, query, args)
+}
 
-	// otherwise query row without transaction
-	return db.DB.QueryContext(ctx, query, args...)
+// query executes a bound query that is expected to return rows
+func (db *DB) query(ctx context.Context, query string, args []interface{}) (*sql.Rows, error) {
+	op := &Op{Kind: OpQuery, Query: query, Args: args}
+	ctx, after, err := db.intercept(ctx, op)
+	if err != nil {
+		return nil, err
+	}
+	query, args = op.Query, op.Args
+	if args, err = db.encryptArgs(args); err != nil {
+		after(err)
+		return nil, err
+	}
+
+	ctx, span := db.startSpan(ctx, spanQuery, query)
+	start := time.Now()
+
+	// use the transaction if it is already started,
+	// otherwise query rows without transaction
+	q, release := db.querier(ctx, query, false)
+	rows, err := q.QueryContext(ctx, query, args...)
+	release()
+
+	db.logSlowQuery(ctx, query, args, time.Since(start))
+	endSpan(span, err)
+	after(err)
+	return rows, err
 }
 
-// WithTransaction executes a function within a transaction
-func (db *DB) WithTransaction(ctx context.Context, fn func(context.Context) error) error {
-	// if transaction is already started then use it
-	if _, ok := ctx.Value(txKey).(*sql.Tx); ok {
-		return fn(ctx)
+// rows executes a query for Get, Select and Stream, reading its result through the cache
+// if the context uses it
+func (db *DB) rows(ctx context.Context, query string, args []interface{}) (resultRows, error) {
+	query, args, err := db.bind(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	if o, ok := db.cacheOptions(ctx); ok {
+		res, err := db.cachedQuery(ctx, OpQuery, o, query, args)
+		if err != nil {
+			return nil, err
+		}
+		return db.decryptRows(newCachedRows(res)), nil
+	}
+
+	rows, err := db.query(ctx, query, args)
+	if err != nil {
+		return nil, err
 	}
+	return db.decryptRows(rows), nil
+}
+
+// WithTransaction executes a function within a transaction.
+// If a transaction is already started, the function is executed within a savepoint
+// of it, so that an error or panic only rolls back the work done by the function.
+// A nested call fails with ErrIncompatibleTxOptions if it asks for stricter options
+// than the outer transaction has.
+func (db *DB) WithTransaction(ctx context.Context, fn func(context.Context) error, opts ...TxOption) (err error) {
+	o := newTxOptions(opts)
+
+	// the timeout bounds the transaction and every statement in it
+	parent := ctx
+	ctx, cancel := o.withTimeout(ctx)
+	defer cancel()
+
+	// if transaction is already started then open a savepoint in it
+	if t, ok := txFromContext(ctx); ok {
+		if err = o.compatible(t.opts); err != nil {
+			return err
+		}
+		return db.withSavepoint(parent, ctx, t, fn)
+	}
+
+	// track the transaction until it has ended, unless the database is shutting down
+	ctx, f, err := db.track(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.untrack(f)
+
+	// the transaction span is the parent of its statements
+	ctx, span := db.startSpan(ctx, spanTransaction, "")
+	defer func() { endSpanWithPanic(span, err, recover()) }()
+
+	// log the transaction if it is slow, or if it is held open for too long
+	start := time.Now()
+	defer func() { db.logSlowTransaction(ctx, time.Since(start), err) }()
+	defer db.watchLongTransaction(ctx, f)()
 
 	// otherwise start a transaction
-	var err error
 	var tx *sql.Tx
 
-	tx, err = db.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelDefault})
+	ctx, tx, err = db.begin(ctx, o)
 	if err != nil {
 		return err
 	}
 
 	// create a new context with the transaction
-	txCtx := context.WithValue(ctx, txKey, tx)
-
-	// execute callback in background
-	pch, ech := doTx(txCtx, fn)
-
-	// wait for the callback to finish or context cancled
-	select {
-	case <-ctx.Done():
-		// if the parent context is cancled
-		// double check if the rollback is called
-		if rerr := tx.Rollback(); rerr != nil {
-			if errors.Is(rerr, sql.ErrTxDone) {
-				// ignore this error
-			} else {
-				return fmt.Errorf("%s: %w", rerr, ctx.Err())
+	t := &transaction{tx: tx, opts: o}
+	txCtx := context.WithValue(ctx, txKey, t)
+
+	// run the hooks once the transaction has ended
+	committed := false
+	defer func() { t.runHooks(ctx, committed) }()
+
+	// execute the callback on the caller goroutine,
+	// if it panics rollback the transaction and repanic
+	defer func() {
+		if r := recover(); r != nil {
+			if rerr := db.rollback(ctx, tx); rerr != nil && !errors.Is(rerr, sql.ErrTxDone) {
+				panic(fmt.Sprintf("%s: %v", rerr, r))
 			}
+			panic(r)
+		}
+	}()
+
+	ferr := doTx(ctx, txCtx, fn)
+	if ferr != nil {
+		// if the callback finished with error or the parent context is cancled
+		// rollback the transaction and return the error,
+		// the transaction may have been rolled back by the driver already
+		if rerr := db.rollback(ctx, tx); rerr != nil && !errors.Is(rerr, sql.ErrTxDone) {
+			return fmt.Errorf("%v: %w", ferr, rerr)
 		}
+		return ferr
+	}
+
+	// if the callback finished without error
+	// commit the transaction and return the error
+	if err = db.commit(ctx, tx); err == nil {
+		committed = true
+	}
+	return err
+}
 
-		return ctx.Err()
-	case r, ok := <-pch:
-		if ok {
-			// if the callback has panic
-			// rollback the transaction and repanic
-			if rerr := tx.Rollback(); rerr != nil {
-				if errors.Is(rerr, sql.ErrTxDone) {
-					// ignore this error
-				} else {
-					panic(fmt.Sprintf("%s: %v", rerr, r))
-				}
+// withSavepoint executes a function within a savepoint of the given transaction.
+// The parent context is the context of the outer transaction, ctx may be bounded
+// by the timeout of the nested call.
+func (db *DB) withSavepoint(parent, ctx context.Context, t *transaction, fn func(context.Context) error) (err error) {
+	ctx, span := db.startSpan(ctx, spanSavepoint, "")
+	defer func() { endSpanWithPanic(span, err, recover()) }()
+
+	name := t.nextSavepoint()
+	if _, err = t.tx.ExecContext(ctx, db.Dialect.savepoint(name)); err != nil {
+		return err
+	}
+	mark := t.markHooks()
+
+	// execute the callback on the caller goroutine,
+	// if it panics rollback to the savepoint and repanic
+	defer func() {
+		if r := recover(); r != nil {
+			t.rollbackHooks(mark)
+			if _, rerr := t.tx.ExecContext(parent, db.Dialect.rollbackToSavepoint(name)); rerr != nil {
+				panic(fmt.Sprintf("%s: %v", rerr, r))
 			}
 			panic(r)
 		}
-	case ferr, ok := <-ech:
-		switch {
-		case ok && ferr != nil:
-			// if the callback finished with error
-			// rollback the transaction and return the error
-			if rerr := tx.Rollback(); rerr != nil {
-				if errors.Is(rerr, sql.ErrTxDone) {
-					// ignore this error
-				} else {
-					return fmt.Errorf("%v: %w", ferr, rerr)
-				}
-			}
+	}()
+
+	if ferr := doTx(ctx, ctx, fn); ferr != nil {
+		// if the context of the outer transaction is cancled
+		// the owner of the transaction rolls it back entirely
+		if parent.Err() != nil {
 			return ferr
-		case ok && ferr == nil:
-			// if the callback finished without error
-			// commit the transaction and return the error
-			return tx.Commit()
 		}
-	}
 
-	// something went wrong, we should never reach here
-	// try to rollback the transaction, and panic
-	if rerr := tx.Rollback(); rerr != nil {
-		if errors.Is(rerr, sql.ErrTxDone) {
-			// ignore this error
-		} else {
-			panic(fmt.Sprintf("%s: db(trx): something went wrong", rerr))
+		// if the callback finished with error, or its own timeout has expired,
+		// rollback to the savepoint within the outer transaction and return the error
+		t.rollbackHooks(mark)
+		if _, rerr := t.tx.ExecContext(parent, db.Dialect.rollbackToSavepoint(name)); rerr != nil {
+			return fmt.Errorf("%v: %w", ferr, rerr)
 		}
+		return ferr
+	}
+
+	// if the callback finished without error
+	// release the savepoint if the dialect supports it
+	if release := db.Dialect.releaseSavepoint(name); release != "" {
+		_, err = t.tx.ExecContext(ctx, release)
 	}
-	panic("db(trx): something went wrong")
+	return err
 }
 
-// Exec executes a query within a transaction that doesn't return rows
+// Exec executes a query within a transaction that doesn't return rows.
+// The arguments are bound like Query.
+// In autocommit mode the query is executed without a transaction,
+// unless one is started in the context.
 func (db *DB) Exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	query, args, err := db.bind(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	// if transaction is already started then use it,
+	// or execute the query alone in autocommit mode
+	if InTransaction(ctx) || db.autocommit(ctx) {
+		return db.exec(ctx, query, args)
+	}
+
+	// otherwise execute within a new transaction
 	var res sql.Result
 
-	err := db.WithTransaction(ctx, func(ctx context.Context) error {
+	err = db.WithTransaction(ctx, func(ctx context.Context) error {
 		// extract the transaction from the context
-		if tx, ok := ctx.Value(txKey).(*sql.Tx); ok {
+		if InTransaction(ctx) {
 			var eerr error
-			res, eerr = tx.ExecContext(ctx, query, args...)
+			res, eerr = db.exec(ctx, query, args)
 			return eerr
 		}
 
@@ -220,3 +536,64 @@ func (db *DB) Exec(ctx context.Context, query string, args ...interface{}) (sql.
 
 	return res, err
 }
+
+// ExecRaw executes a statement as is, within the transaction of the context if any, or alone.
+// It is not bound, intercepted nor prepared, and does not invalidate the cache: it runs the SQL written
+// for the database itself, like schema migrations, where ? and :name are not parameters.
+func (db *DB) ExecRaw(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, span := db.startSpan(ctx, spanExec, query)
+	start := time.Now()
+
+	var res sql.Result
+	var err error
+	if t, ok := txFromContext(ctx); ok {
+		res, err = t.tx.ExecContext(ctx, query, args...)
+	} else {
+		res, err = db.DB.ExecContext(ctx, query, args...)
+	}
+
+	db.logSlowQuery(ctx, query, args, time.Since(start))
+	endExecSpan(span, res, err)
+	return res, err
+}
+
+// UseAutocommit returns a context in which Exec executes its statement
+// without a transaction or not, whatever the Autocommit mode of the database
+func UseAutocommit(ctx context.Context, autocommit bool) context.Context {
+	return context.WithValue(ctx, autocommitKey, autocommit)
+}
+
+// autocommit reports whether Exec executes its statement without a transaction in the context
+func (db *DB) autocommit(ctx context.Context) bool {
+	if autocommit, ok := ctx.Value(autocommitKey).(bool); ok {
+		return autocommit
+	}
+	return db.Autocommit
+}
+
+// exec executes a query within the transaction of the context, if any
+func (db *DB) exec(ctx context.Context, query string, args []interface{}) (sql.Result, error) {
+	op := &Op{Kind: OpExec, Query: query, Args: args}
+	ctx, after, err := db.intercept(ctx, op)
+	if err != nil {
+		return nil, err
+	}
+	query, args = op.Query, op.Args
+	if args, err = db.encryptArgs(args); err != nil {
+		after(err)
+		return nil, err
+	}
+
+	ctx, span := db.startSpan(ctx, spanExec, query)
+	start := time.Now()
+	q, release := db.querier(ctx, query, true)
+	res, err := q.ExecContext(ctx, query, args...)
+	release()
+	db.logSlowQuery(ctx, query, args, time.Since(start))
+	endExecSpan(span, res, err)
+	after(err)
+	if err == nil {
+		db.invalidateWritten(ctx, query)
+	}
+	return res, err
+}
@@ -2,13 +2,25 @@ package db
 
 import (
 	"context"
+	"database/sql"
+	"database/sql/driver"
 	"fmt"
+	"math"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/metric/metrictest"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
 )
 
 type DBTestSuite struct {
@@ -192,6 +204,959 @@ func (s *DBTestSuite) TestTransactionRollbackParentCancelBBPanic() {
 	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
 }
 
+// TestNestedTransactionRelease will test the nested transaction ended with savepoint release.
+func (s *DBTestSuite) TestNestedTransactionRelease() {
+	ctx := context.Background()
+
+	// mock
+	q := "INSERT INTO `users` (`name`, `email`) VALUES (?, ?)"
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec("SAVEPOINT sp_1").WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectExec(q).WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectExec("RELEASE SAVEPOINT sp_1").WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectCommit()
+
+	// test
+	err := s.db.WithTransaction(ctx, func(txCtx context.Context) error {
+		return s.db.WithTransaction(txCtx, func(spCtx context.Context) error {
+			_, err := s.db.Exec(spCtx, q, "name", "email")
+			return err
+		})
+	})
+
+	assert.NoError(s.T(), err)
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
+
+// TestNestedTransactionRollback will test the nested transaction rolled back to its savepoint
+// while the outer transaction is committed.
+func (s *DBTestSuite) TestNestedTransactionRollback() {
+	ctx := context.Background()
+
+	// mock
+	q := "INSERT INTO `users` (`name`, `email`) VALUES (?, ?)"
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(q).WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectExec("SAVEPOINT sp_1").WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectExec(q).WillReturnResult(sqlmock.NewResult(2, 1))
+	s.mock.ExpectExec("ROLLBACK TO SAVEPOINT sp_1").WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectCommit()
+
+	// test
+	targetErr := fmt.Errorf("application error occurred")
+	err := s.db.WithTransaction(ctx, func(txCtx context.Context) error {
+		if _, err := s.db.Exec(txCtx, q, "name", "email"); err != nil {
+			return err
+		}
+
+		spErr := s.db.WithTransaction(txCtx, func(spCtx context.Context) error {
+			_, err := s.db.Exec(spCtx, q, "name", "email")
+			assert.NoError(s.T(), err)
+
+			return targetErr
+		})
+		assert.ErrorIs(s.T(), spErr, targetErr)
+
+		return nil
+	})
+
+	assert.NoError(s.T(), err)
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
+
+// TestNestedTransactionTimeout will test the nested transaction rolled back to its savepoint
+// once its own timeout has expired, while the outer transaction is committed.
+func (s *DBTestSuite) TestNestedTransactionTimeout() {
+	ctx := context.Background()
+
+	// mock
+	q := "INSERT INTO `users` (`name`, `email`) VALUES (?, ?)"
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec("SAVEPOINT sp_1").WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectExec(q).WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectExec("ROLLBACK TO SAVEPOINT sp_1").WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectCommit()
+
+	// test
+	err := s.db.WithTransaction(ctx, func(txCtx context.Context) error {
+		spErr := s.db.WithTransaction(txCtx, func(spCtx context.Context) error {
+			if _, err := s.db.Exec(spCtx, q, "name", "email"); err != nil {
+				return err
+			}
+			<-spCtx.Done()
+			return nil
+		}, Timeout(10*time.Millisecond))
+		assert.ErrorIs(s.T(), spErr, context.DeadlineExceeded)
+
+		return nil
+	})
+
+	assert.NoError(s.T(), err)
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
+
+// TestNestedTransactionRollbackWithPanic will test the nested transaction rolled back to its savepoint
+// on panic, and the outer transaction rolled back by the repanic.
+func (s *DBTestSuite) TestNestedTransactionRollbackWithPanic() {
+	ctx := context.Background()
+
+	// mock
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec("SAVE TRANSACTION sp_1").WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectExec("ROLLBACK TRANSACTION sp_1").WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectRollback()
+
+	// test
+	s.db.Dialect = SQLServer
+	targetErr := fmt.Errorf("application error occurred")
+	var err error
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				err = r.(error)
+			}
+		}()
+
+		err = s.db.WithTransaction(ctx, func(txCtx context.Context) error {
+			return s.db.WithTransaction(txCtx, func(spCtx context.Context) error {
+				panic(targetErr)
+			})
+		})
+	}()
+
+	assert.ErrorIs(s.T(), err, targetErr)
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
+
+// TestTransactionOptions will test the transaction started with the given options.
+func (s *DBTestSuite) TestTransactionOptions() {
+	ctx := context.Background()
+
+	// mock
+	q := "SELECT `name` FROM `users` WHERE `id` = ?"
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery(q).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("name"))
+	s.mock.ExpectCommit()
+
+	// test
+	err := s.db.WithTransaction(ctx, func(txCtx context.Context) error {
+		deadline, ok := txCtx.Deadline()
+		assert.True(s.T(), ok)
+		assert.WithinDuration(s.T(), time.Now().Add(time.Minute), deadline, time.Second)
+
+		var name string
+		return s.db.QueryRow(txCtx, q, 1).Scan(&name)
+	}, Isolation(sql.LevelSerializable), ReadOnly(), Timeout(time.Minute))
+
+	assert.NoError(s.T(), err)
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
+
+// TestNestedTransactionIncompatibleOptions will test the nested transaction asking for
+// stricter options than the outer transaction.
+func (s *DBTestSuite) TestNestedTransactionIncompatibleOptions() {
+	ctx := context.Background()
+
+	// mock
+	s.mock.ExpectBegin()
+	s.mock.ExpectCommit()
+
+	// test
+	err := s.db.WithTransaction(ctx, func(txCtx context.Context) error {
+		nop := func(context.Context) error { return nil }
+
+		err := s.db.WithTransaction(txCtx, nop, Isolation(sql.LevelSerializable))
+		assert.ErrorIs(s.T(), err, ErrIncompatibleTxOptions)
+
+		err = s.db.WithTransaction(txCtx, nop, ReadOnly())
+		assert.ErrorIs(s.T(), err, ErrIncompatibleTxOptions)
+
+		return nil
+	}, Isolation(sql.LevelReadCommitted))
+
+	assert.NoError(s.T(), err)
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
+
+// TestRetryTransaction will test the transaction retried after a deadlock.
+func (s *DBTestSuite) TestRetryTransaction() {
+	ctx := context.Background()
+
+	// mock
+	q := "UPDATE `accounts` SET `balance` = `balance` - ? WHERE `id` = ?"
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(q).WillReturnError(fmt.Errorf("Error 1213: Deadlock found when trying to get lock"))
+	s.mock.ExpectRollback()
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(q).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+
+	// test
+	s.db.RetryPolicy = RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, Retryable: IsRetryable}
+	attempts := 0
+	err := s.db.WithRetryTransaction(ctx, func(txCtx context.Context) error {
+		attempts++
+		_, err := s.db.Exec(txCtx, q, 100, 1)
+		return err
+	})
+
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), 2, attempts)
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
+
+// TestRetryTransactionNotRetryable will test the transaction not retried after an application error.
+func (s *DBTestSuite) TestRetryTransactionNotRetryable() {
+	ctx := context.Background()
+
+	// mock
+	s.mock.ExpectBegin()
+	s.mock.ExpectRollback()
+
+	// test
+	targetErr := fmt.Errorf("application error occurred")
+	attempts := 0
+	err := s.db.WithRetryTransaction(ctx, func(txCtx context.Context) error {
+		attempts++
+		return targetErr
+	})
+
+	assert.ErrorIs(s.T(), err, targetErr)
+	assert.Equal(s.T(), 1, attempts)
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
+
+type timestamps struct {
+	CreatedAt time.Time `db:"created_at"`
+}
+
+type user struct {
+	timestamps
+	ID       int64          `db:"id"`
+	Name     string         `db:"name"`
+	Email    sql.NullString `db:"email"`
+	Password string         `db:"-"`
+}
+
+// TestGet will test a row scanned into a struct.
+func (s *DBTestSuite) TestGet() {
+	ctx := context.Background()
+
+	// mock
+	q := "SELECT `id`, `name`, `email`, `created_at` FROM `users` WHERE `id` = ?"
+	now := time.Now()
+	s.mock.ExpectQuery(q).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "name", "email", "created_at"}).AddRow(1, "name", nil, now),
+	)
+	s.mock.ExpectQuery(q).WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email", "created_at"}))
+
+	// test
+	u, err := Get[*user](ctx, s.db, q, 1)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), &user{timestamps: timestamps{CreatedAt: now}, ID: 1, Name: "name"}, u)
+
+	_, err = Get[user](ctx, s.db, q, 2)
+	assert.ErrorIs(s.T(), err, sql.ErrNoRows)
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
+
+// TestSelect will test rows scanned into a slice.
+func (s *DBTestSuite) TestSelect() {
+	ctx := context.Background()
+
+	// mock
+	q := "SELECT `name` FROM `users`"
+	s.mock.ExpectQuery(q).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a").AddRow("b"))
+
+	// test
+	names, err := Select[string](ctx, s.db, q)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), []string{"a", "b"}, names)
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
+
+// TestSelectColumnMismatch will test the errors of columns not matching the struct.
+func (s *DBTestSuite) TestSelectColumnMismatch() {
+	ctx := context.Background()
+
+	// mock
+	q := "SELECT * FROM `users`"
+	s.mock.ExpectQuery(q).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "name", "email", "created_at", "deleted_at"}),
+	)
+	s.mock.ExpectQuery(q).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}))
+
+	// test
+	_, err := Select[user](ctx, s.db, q)
+	assert.ErrorIs(s.T(), err, ErrUnmappedColumn)
+
+	_, err = Select[user](ctx, s.db, q)
+	assert.ErrorIs(s.T(), err, ErrMissingColumn)
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
+
+type Audit struct {
+	UpdatedAt time.Time `db:"updated_at"`
+	Version   int
+}
+
+type account struct {
+	*Audit
+	ID int64 `db:"id"`
+}
+
+// TestGetEmbeddedPointer will test a row scanned into a struct embedding a pointer to a struct.
+func (s *DBTestSuite) TestGetEmbeddedPointer() {
+	ctx := context.Background()
+
+	// mock
+	q := "SELECT `id`, `updated_at`, `version` FROM `accounts` WHERE `id` = ?"
+	now := time.Now()
+	s.mock.ExpectQuery(q).WithArgs(1).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "updated_at", "version"}).AddRow(1, now, 3),
+	)
+
+	// test
+	a, err := Get[account](ctx, s.db, q, 1)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), account{Audit: &Audit{UpdatedAt: now, Version: 3}, ID: 1}, a)
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
+
+// TestStream will test rows iterated one by one.
+func (s *DBTestSuite) TestStream() {
+	ctx := context.Background()
+
+	// mock
+	q := "SELECT `name` FROM `users`"
+	s.mock.ExpectQuery(q).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a").AddRow("b")).RowsWillBeClosed()
+	s.mock.ExpectQuery(q).WillReturnRows(
+		sqlmock.NewRows([]string{"name"}).AddRow("a").AddRow("b").RowError(1, fmt.Errorf("connection lost")),
+	).RowsWillBeClosed()
+
+	// test
+	it, err := Stream[string](ctx, s.db, q)
+	assert.NoError(s.T(), err)
+	var names []string
+	for it.Next() {
+		names = append(names, it.Value())
+	}
+	assert.NoError(s.T(), it.Err())
+	assert.Equal(s.T(), []string{"a", "b"}, names)
+
+	upper := func(row Scanable) (string, error) {
+		var name string
+		err := row.Scan(&name)
+		return strings.ToUpper(name), err
+	}
+	it, err = StreamFunc(ctx, s.db, upper, q)
+	assert.NoError(s.T(), err)
+	names = nil
+	for it.Next() {
+		names = append(names, it.Value())
+	}
+	assert.EqualError(s.T(), it.Err(), "connection lost")
+	assert.Equal(s.T(), []string{"A"}, names)
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
+
+// TestStreamCancel will test the iteration ended by the cancelation of its context.
+func (s *DBTestSuite) TestStreamCancel() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// mock
+	q := "SELECT `name` FROM `users`"
+	s.mock.ExpectQuery(q).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a").AddRow("b")).RowsWillBeClosed()
+
+	// test
+	it, err := Stream[string](ctx, s.db, q)
+	assert.NoError(s.T(), err)
+	assert.True(s.T(), it.Next())
+	cancel()
+	assert.False(s.T(), it.Next())
+	assert.ErrorIs(s.T(), it.Err(), context.Canceled)
+	assert.ErrorIs(s.T(), it.Close(), context.Canceled)
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
+
+// TestStreamBatches will test rows iterated in batches.
+func (s *DBTestSuite) TestStreamBatches() {
+	ctx := context.Background()
+
+	// mock
+	q := "SELECT `id`, `name`, `email`, `created_at` FROM `users`"
+	rows := sqlmock.NewRows([]string{"id", "name", "email", "created_at"})
+	for i := 1; i <= 5; i++ {
+		rows.AddRow(i, "name", nil, time.Time{})
+	}
+	s.mock.ExpectQuery(q).WillReturnRows(rows).RowsWillBeClosed()
+
+	// test
+	b, err := StreamBatches[user](ctx, s.db, 2, q)
+	assert.NoError(s.T(), err)
+	var sizes []int
+	for b.Next() {
+		sizes = append(sizes, len(b.Value()))
+	}
+	assert.NoError(s.T(), b.Err())
+	assert.Equal(s.T(), []int{2, 2, 1}, sizes)
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
+
+// TestTransactionSpans will test the transaction span is the parent of its statement spans.
+func (s *DBTestSuite) TestTransactionSpans() {
+	ctx := context.Background()
+	recorder := tracetest.NewSpanRecorder()
+	s.db.TracerProvider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	s.db.Dialect = MySQL
+
+	// mock
+	q := "INSERT INTO `users` (`name`, `email`) VALUES (?, 'email')"
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(q).WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectExec(q).WillReturnError(fmt.Errorf("duplicated"))
+	s.mock.ExpectRollback()
+
+	// test
+	err := s.db.WithTransaction(ctx, func(txCtx context.Context) error {
+		if _, err := s.db.Exec(txCtx, q, "name"); err != nil {
+			return err
+		}
+		_, err := s.db.Exec(txCtx, q, "name")
+		return err
+	})
+	assert.Error(s.T(), err)
+
+	spans := recorder.Ended()
+	if !assert.Len(s.T(), spans, 3) {
+		return
+	}
+	tx := spans[2]
+	assert.Equal(s.T(), spanTransaction, tx.Name())
+	assert.Equal(s.T(), "Error", tx.Status().Code.String())
+
+	for _, stmt := range spans[:2] {
+		assert.Equal(s.T(), spanExec, stmt.Name())
+		assert.Equal(s.T(), tx.SpanContext().SpanID(), stmt.Parent().SpanID())
+		assert.Contains(s.T(), stmt.Attributes(), semconv.DBSystemMySQL)
+		assert.Contains(s.T(), stmt.Attributes(), semconv.DBStatementKey.String("INSERT INTO `users` (`name`, `email`) VALUES (?, ?)"))
+	}
+	assert.Contains(s.T(), spans[0].Attributes(), dbRowsAffectedKey.Int64(1))
+	assert.Equal(s.T(), "Error", spans[1].Status().Code.String())
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
+
+// TestSlowQueryLog will test the slow statements and transactions logged.
+func (s *DBTestSuite) TestSlowQueryLog() {
+	ctx := context.Background()
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+	s.db.SlowQueryThreshold = 10 * time.Millisecond
+	s.db.SlowTransactionThreshold = 30 * time.Millisecond
+	s.db.LongTransactionThreshold = 40 * time.Millisecond
+
+	// mock
+	q := "UPDATE `users` SET `name` = 'name' WHERE `id` IN (?, ?)"
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(q).WillReturnResult(sqlmock.NewResult(0, 2))
+	s.mock.ExpectExec(q).WillDelayFor(20 * time.Millisecond).WillReturnResult(sqlmock.NewResult(0, 2))
+	s.mock.ExpectCommit()
+
+	// test
+	err := s.db.WithTransaction(ctx, func(txCtx context.Context) error {
+		if _, err := s.db.Exec(txCtx, q, 1, "secret"); err != nil {
+			return err
+		}
+		if _, err := s.db.Exec(txCtx, q, 1, "secret"); err != nil {
+			return err
+		}
+		time.Sleep(30 * time.Millisecond)
+		return nil
+	})
+	assert.NoError(s.T(), err)
+
+	entries := hook.AllEntries()
+	if !assert.Len(s.T(), entries, 3) {
+		return
+	}
+
+	assert.Equal(s.T(), "db: slow query", entries[0].Message)
+	assert.Equal(s.T(), logrus.WarnLevel, entries[0].Level)
+	assert.Equal(s.T(), "UPDATE `users` SET `name` = ? WHERE `id` IN (?+)", entries[0].Data["db_fingerprint"])
+	assert.Equal(s.T(), "[int string(6)]", entries[0].Data["db_args"])
+	assert.Contains(s.T(), entries[0].Data, "trace")
+
+	assert.Equal(s.T(), "db: long transaction", entries[1].Message)
+	assert.Contains(s.T(), entries[1].Data["db_stack"], "TestSlowQueryLog")
+
+	assert.Equal(s.T(), "db: slow transaction", entries[2].Message)
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
+
+// TestAfterCommitHooks will test the hooks run once the outermost transaction has committed.
+func (s *DBTestSuite) TestAfterCommitHooks() {
+	ctx := context.Background()
+
+	// mock
+	q := "INSERT INTO `users` (`name`, `email`) VALUES (?, ?)"
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(q).WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectExec("SAVEPOINT sp_1").WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectExec("ROLLBACK TO SAVEPOINT sp_1").WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectCommit()
+
+	// test
+	var calls []string
+	hook := func(name string) func(context.Context) {
+		return func(context.Context) {
+			assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+			calls = append(calls, name)
+		}
+	}
+	err := s.db.WithTransaction(ctx, func(txCtx context.Context) error {
+		if _, err := s.db.Exec(txCtx, q, "name", "email"); err != nil {
+			return err
+		}
+		AfterCommit(txCtx, hook("outer commit"))
+		AfterRollback(txCtx, hook("outer rollback"))
+
+		_ = s.db.WithTransaction(txCtx, func(spCtx context.Context) error {
+			AfterCommit(spCtx, hook("inner commit"))
+			AfterRollback(spCtx, hook("inner rollback"))
+			return fmt.Errorf("application error occurred")
+		})
+
+		assert.Empty(s.T(), calls)
+		return nil
+	})
+
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), []string{"outer commit", "inner rollback"}, calls)
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
+
+// TestAfterRollbackHooks will test the hooks run once the transaction has failed to commit.
+func (s *DBTestSuite) TestAfterRollbackHooks() {
+	ctx := context.Background()
+
+	// mock
+	s.mock.ExpectBegin()
+	s.mock.ExpectCommit().WillReturnError(fmt.Errorf("connection lost"))
+
+	// test
+	var calls []string
+	err := s.db.WithTransaction(ctx, func(txCtx context.Context) error {
+		AfterCommit(txCtx, func(context.Context) { calls = append(calls, "commit") })
+		AfterRollback(txCtx, func(context.Context) { calls = append(calls, "rollback") })
+		return nil
+	})
+
+	assert.Error(s.T(), err)
+	assert.Equal(s.T(), []string{"rollback"}, calls)
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+
+	// outside a transaction
+	calls = nil
+	AfterCommit(ctx, func(context.Context) { calls = append(calls, "commit") })
+	AfterRollback(ctx, func(context.Context) { calls = append(calls, "rollback") })
+	assert.Equal(s.T(), []string{"commit"}, calls)
+}
+
+// TestExecAutocommit will test the statements executed without a transaction.
+func (s *DBTestSuite) TestExecAutocommit() {
+	ctx := context.Background()
+
+	// mock
+	q := "UPDATE `users` SET `name` = ? WHERE `id` = ?"
+	s.mock.ExpectExec(q).WithArgs("alice", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectExec(q).WithArgs("bob", 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(q).WithArgs("carol", 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(q).WithArgs("dave", 4).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+
+	// test
+	// per call
+	_, err := s.db.Exec(UseAutocommit(ctx, true), q, "alice", 1)
+	assert.NoError(s.T(), err)
+
+	// per database
+	s.db.Autocommit = true
+	_, err = s.db.Exec(ctx, q, "bob", 2)
+	assert.NoError(s.T(), err)
+
+	// an existing transaction is joined
+	err = s.db.WithTransaction(ctx, func(txCtx context.Context) error {
+		_, eerr := s.db.Exec(txCtx, q, "carol", 3)
+		return eerr
+	})
+	assert.NoError(s.T(), err)
+
+	// per call override
+	_, err = s.db.Exec(UseAutocommit(ctx, false), q, "dave", 4)
+	assert.NoError(s.T(), err)
+
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
+
 func TestDBTestSuite(t *testing.T) {
 	suite.Run(t, new(DBTestSuite))
 }
+
+type sqlStateError string
+
+func (e sqlStateError) Error() string    { return "sql state " + string(e) }
+func (e sqlStateError) SQLState() string { return string(e) }
+
+type Tree struct {
+	*Tree
+	ID int64 `db:"id"`
+}
+
+func TestFieldsOf(t *testing.T) {
+	type named struct {
+		Name string
+	}
+	type labeled struct {
+		Name  string
+		Label string `db:"name"`
+	}
+	type titled struct {
+		Name string
+	}
+	type ambiguous struct {
+		named
+		titled
+		ID int64 `db:"id"`
+	}
+	type shadowed struct {
+		named
+		Name string `db:"name"`
+	}
+
+	// the fields of the same name at the same depth are ambiguous, unless only one is tagged
+	fields := fieldsOf(reflect.TypeOf(labeled{}))
+	assert.Equal(t, []structField{{name: "name", index: []int{1}}}, fields.fields)
+	fields = fieldsOf(reflect.TypeOf(ambiguous{}))
+	assert.Equal(t, []structField{{name: "id", index: []int{2}}}, fields.fields)
+	assert.True(t, fields.ambiguous["name"])
+	_, err := newRowScanner[ambiguous]([]string{"id", "name"})
+	assert.ErrorIs(t, err, ErrUnmappedColumn)
+	assert.Contains(t, err.Error(), "name is ambiguous")
+
+	// the outer fields shadow the embedded ones
+	fields = fieldsOf(reflect.TypeOf(shadowed{}))
+	assert.Equal(t, []structField{{name: "name", index: []int{1}}}, fields.fields)
+
+	// a struct embedding itself
+	fields = fieldsOf(reflect.TypeOf(Tree{}))
+	assert.Equal(t, []structField{{name: "id", index: []int{1}}}, fields.fields)
+	assert.Nil(t, fieldValue(reflect.ValueOf(account{}), []int{0, 1}))
+}
+
+func TestIsRetryable(t *testing.T) {
+	assert.True(t, IsRetryable(sqlStateError("40001")))
+	assert.True(t, IsRetryable(fmt.Errorf("wrapped: %w", sqlStateError("40P01"))))
+	assert.True(t, IsRetryable(fmt.Errorf("ERROR: could not serialize access (SQLSTATE 40001)")))
+	assert.True(t, IsRetryable(fmt.Errorf("Error 1213 (40001): Deadlock found when trying to get lock")))
+	assert.False(t, IsRetryable(sqlStateError("23505")))
+	assert.False(t, IsRetryable(fmt.Errorf("Error 1062: Duplicate entry")))
+	assert.False(t, IsRetryable(nil))
+}
+
+func TestRetryBackoff(t *testing.T) {
+	// between half of the delay and the delay
+	inRange := func(t *testing.T, d, delay time.Duration) {
+		t.Helper()
+		assert.GreaterOrEqual(t, d, delay/2)
+		assert.LessOrEqual(t, d, delay)
+	}
+
+	p := RetryPolicy{BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+	inRange(t, p.backoff(1), 10*time.Millisecond)
+	inRange(t, p.backoff(2), 20*time.Millisecond)
+	inRange(t, p.backoff(3), 40*time.Millisecond)
+	inRange(t, p.backoff(4), 50*time.Millisecond)
+	inRange(t, p.backoff(10), 50*time.Millisecond)
+
+	// without max delay
+	p = RetryPolicy{BaseDelay: 10 * time.Millisecond}
+	inRange(t, p.backoff(1), 10*time.Millisecond)
+	inRange(t, p.backoff(4), 80*time.Millisecond)
+	// doubled until it would overflow
+	assert.Greater(t, p.backoff(200), time.Duration(math.MaxInt64/4))
+
+	assert.Zero(t, RetryPolicy{}.backoff(3))
+}
+
+// mockConnector is a driver.Connector of a sqlmock database
+type mockConnector struct {
+	dsn    string
+	driver driver.Driver
+}
+
+func (c *mockConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c *mockConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+func newMockConnector(t *testing.T, dsn string) (driver.Connector, sqlmock.Sqlmock) {
+	mockdb, mock, err := sqlmock.NewWithDSN(dsn, sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the dsn is freed once the connections of the test are closed
+	t.Cleanup(func() { mockdb.Close() })
+	return &mockConnector{dsn: dsn, driver: mockdb.Driver()}, mock
+}
+
+func TestReplicaRouting(t *testing.T) {
+	ctx := context.Background()
+	primary, primaryMock := newMockConnector(t, "primary")
+	replica, replicaMock := newMockConnector(t, "replica")
+
+	db, err := New(primary, WithReplicas(replica), WithReplicaHealthCheckInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// mock
+	q := "SELECT `name` FROM `users` WHERE `id` = ?"
+	e := "UPDATE `users` SET `name` = ? WHERE `id` = ?"
+	replicaMock.ExpectQuery(q).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("replica"))
+	primaryMock.ExpectQuery(q).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("primary"))
+	primaryMock.ExpectBegin()
+	primaryMock.ExpectExec(e).WillReturnResult(sqlmock.NewResult(0, 1))
+	primaryMock.ExpectCommit()
+	primaryMock.ExpectQuery(q).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("fallback"))
+
+	// test
+	var name string
+	assert.NoError(t, db.QueryRow(ctx, q, 1).Scan(&name))
+	assert.Equal(t, "replica", name)
+
+	assert.NoError(t, db.QueryRow(UsePrimary(ctx), q, 1).Scan(&name))
+	assert.Equal(t, "primary", name)
+
+	_, err = db.Exec(ctx, e, "name", 1)
+	assert.NoError(t, err)
+
+	// unhealthy replicas are skipped
+	db.replicas.replicas[0].setHealthy(false)
+	assert.NoError(t, db.QueryRow(ctx, q, 1).Scan(&name))
+	assert.Equal(t, "fallback", name)
+
+	assert.NoError(t, primaryMock.ExpectationsWereMet())
+	assert.NoError(t, replicaMock.ExpectationsWereMet())
+}
+
+func TestReplicaHealthCheck(t *testing.T) {
+	var dbs []*sql.DB
+	var mocks []sqlmock.Sqlmock
+	for i := 0; i < 3; i++ {
+		mockdb, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer mockdb.Close()
+		dbs, mocks = append(dbs, mockdb), append(mocks, mock)
+	}
+
+	// two replicas hang, they are pinged concurrently
+	mocks[0].ExpectPing().WillDelayFor(time.Second)
+	mocks[1].ExpectPing().WillDelayFor(time.Second)
+	mocks[2].ExpectPing()
+
+	rs := newReplicaSet(dbs, RoundRobin)
+	start := time.Now()
+	rs.ping(100 * time.Millisecond)
+	assert.Less(t, time.Since(start), 200*time.Millisecond)
+	assert.False(t, rs.replicas[0].isHealthy())
+	assert.False(t, rs.replicas[1].isHealthy())
+	assert.True(t, rs.replicas[2].isHealthy())
+}
+
+func TestPoolMetrics(t *testing.T) {
+	ctx := context.Background()
+	primary, _ := newMockConnector(t, "metrics")
+	mp, exp := metrictest.NewTestMeterProvider()
+
+	db, err := New(primary, WithName("users"), WithMaxOpenConns(10), WithMeterProvider(mp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// test
+	assert.NoError(t, exp.Collect(ctx))
+
+	attrs := []attribute.KeyValue{semconv.DBNameKey.String("users"), dbPoolKey.String("primary")}
+	rec, err := exp.GetByNameAndAttributes("db.sql.connections.max_open", attrs)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), rec.LastValue.AsInt64())
+
+	for _, name := range []string{
+		"db.sql.connections.open",
+		"db.sql.connections.in_use",
+		"db.sql.connections.idle",
+		"db.sql.connections.wait_count",
+		"db.sql.connections.wait_duration",
+		"db.sql.connections.max_idle_closed",
+		"db.sql.connections.max_idle_time_closed",
+		"db.sql.connections.max_lifetime_closed",
+	} {
+		_, err := exp.GetByNameAndAttributes(name, attrs)
+		assert.NoError(t, err, name)
+	}
+}
+
+func TestStmtCache(t *testing.T) {
+	ctx := context.Background()
+	primary, mock := newMockConnector(t, "stmtcache")
+
+	db, err := New(primary, WithStmtCache(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// mock
+	q := "SELECT `name` FROM `users` WHERE `id` = ?"
+	e := "UPDATE `users` SET `name` = ? WHERE `id` = ?"
+	c := "SELECT COUNT(*) FROM `users`"
+	p := mock.ExpectPrepare(q).WillBeClosed()
+	p.ExpectQuery().WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("alice"))
+	p.ExpectQuery().WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("bob"))
+	mock.ExpectBegin()
+	// not prepared on the pool within a transaction
+	mock.ExpectExec(e).WithArgs("carol", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	// evicts the least recently used statement
+	mock.ExpectPrepare(c).ExpectQuery().WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+
+	// test
+	var name string
+	assert.NoError(t, db.QueryRow(ctx, q, 1).Scan(&name))
+	assert.Equal(t, "alice", name)
+	assert.NoError(t, db.QueryRow(ctx, q, 2).Scan(&name))
+	assert.Equal(t, "bob", name)
+
+	_, err = db.Exec(ctx, e, "carol", 1)
+	assert.NoError(t, err)
+
+	var count int
+	assert.NoError(t, db.QueryRow(ctx, c).Scan(&count))
+	assert.Equal(t, 2, count)
+
+	assert.Equal(t, StmtCacheStats{Size: 1, Hits: 1, Misses: 3, Evictions: 1}, db.StmtCacheStats())
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	_, err = New(primary, WithStmtCache(0))
+	assert.Error(t, err)
+}
+
+func TestStmtCacheSingleConn(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	primary, mock := newMockConnector(t, "stmtcache_single")
+
+	db, err := New(primary, WithStmtCache(2), WithMaxOpenConns(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// mock
+	q := "SELECT `name` FROM `users` WHERE `id` = ?"
+	mock.ExpectBegin()
+	mock.ExpectQuery(q).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("alice"))
+	mock.ExpectCommit()
+	p := mock.ExpectPrepare(q)
+	p.ExpectQuery().WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("bob"))
+	mock.ExpectBegin()
+	// rebound to the transaction, on the connection it has been prepared on
+	p.ExpectQuery().WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("carol"))
+	mock.ExpectCommit()
+
+	// test
+	// a miss within a transaction does not wait for a second connection
+	var name string
+	err = db.WithTransaction(ctx, func(txCtx context.Context) error {
+		return db.QueryRow(txCtx, q, 1).Scan(&name)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", name)
+
+	assert.NoError(t, db.QueryRow(ctx, q, 2).Scan(&name))
+	assert.Equal(t, "bob", name)
+
+	err = db.WithTransaction(ctx, func(txCtx context.Context) error {
+		return db.QueryRow(txCtx, q, 3).Scan(&name)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "carol", name)
+
+	assert.Equal(t, StmtCacheStats{Size: 1, Hits: 1, Misses: 2}, db.StmtCacheStats())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// nopConn is a driver connection whose transactions do nothing,
+// to measure the overhead of the package
+type nopConn struct{}
+
+func (nopConn) Prepare(string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (nopConn) Close() error                        { return nil }
+func (nopConn) Begin() (driver.Tx, error)           { return nopConn{}, nil }
+func (nopConn) Commit() error                       { return nil }
+func (nopConn) Rollback() error                     { return nil }
+
+type nopConnector struct{}
+
+func (nopConnector) Connect(context.Context) (driver.Conn, error) { return nopConn{}, nil }
+func (nopConnector) Driver() driver.Driver                        { return nopDriver{} }
+
+type nopDriver struct{}
+
+func (nopDriver) Open(string) (driver.Conn, error) { return nopConn{}, nil }
+
+func BenchmarkWithTransaction(b *testing.B) {
+	ctx := context.Background()
+	db := &DB{DB: sql.OpenDB(nopConnector{})}
+	defer db.Close()
+
+	fn := func(context.Context) error { return nil }
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := db.WithTransaction(ctx, fn); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWithTransactionParallel(b *testing.B) {
+	ctx := context.Background()
+	db := &DB{DB: sql.OpenDB(nopConnector{})}
+	defer db.Close()
+
+	fn := func(context.Context) error { return nil }
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := db.WithTransaction(ctx, fn); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
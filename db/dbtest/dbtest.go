@@ -0,0 +1,63 @@
+// Package dbtest helps testing the code built on top of db.DB.
+//
+// A Recorder wraps the connector of a real database and records the statements, their arguments
+// and their results into a golden file. A Replayer serves the golden file without a database, and
+// fails on the statements which have not been recorded, so that the tests of the repositories stay
+// realistic and deterministic in CI:
+//
+//	func TestUsers(t *testing.T) {
+//		connector := dbtest.Open(t, "testdata/users.json", func() (driver.Connector, error) {
+//			return pq.NewConnector(os.Getenv("DATABASE_URL"))
+//		})
+//		d, err := db.New(connector, db.WithDialect(db.Postgres))
+//		...
+//	}
+//
+// The golden files are recorded by running the tests with the environment variable DBTEST_RECORD=1,
+// and replayed otherwise.
+//
+// Suite is a testify suite wiring sqlmock into a db.DB, with helpers expecting the transactions
+// and asserting that they have ended.
+package dbtest
+
+import (
+	"database/sql/driver"
+	"os"
+	"strconv"
+	"testing"
+)
+
+// recordEnv is the environment variable recording the golden files against the databases, when it is true
+const recordEnv = "DBTEST_RECORD"
+
+// Open returns the connector of a test. With DBTEST_RECORD, it records the interactions with the
+// connector returned by connect into the golden file, written when the test ends. Otherwise, it replays
+// the golden file with the matchers, and fails the test if its interactions have not all been replayed.
+func Open(t testing.TB, path string, connect func() (driver.Connector, error), matchers ...Matcher) driver.Connector {
+	t.Helper()
+
+	if record, _ := strconv.ParseBool(os.Getenv(recordEnv)); record {
+		connector, err := connect()
+		if err != nil {
+			t.Fatalf("dbtest: can not connect to the database: %v", err)
+		}
+		r := NewRecorder(connector, path)
+		t.Cleanup(func() {
+			if err := r.Save(); err != nil {
+				t.Errorf("dbtest: can not save the golden file: %v", err)
+			}
+		})
+		return r
+	}
+
+	r, err := NewReplayer(path, matchers...)
+	if err != nil {
+		t.Fatalf("dbtest: can not read the golden file: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := r.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+	return r
+}
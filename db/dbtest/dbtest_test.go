@@ -0,0 +1,302 @@
+package dbtest
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/org39/gopkg/db"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockConnector is a driver.Connector of a sqlmock database
+type mockConnector struct {
+	dsn    string
+	driver driver.Driver
+}
+
+func (c *mockConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c *mockConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+func newMockConnector(t *testing.T) (driver.Connector, sqlmock.Sqlmock) {
+	dsn := fmt.Sprintf("%s_%d", t.Name(), time.Now().UnixNano())
+	mockdb, mock, err := sqlmock.NewWithDSN(dsn, sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &mockConnector{dsn: dsn, driver: mockdb.Driver()}, mock
+}
+
+type user struct {
+	ID        int64     `db:"id"`
+	Name      string    `db:"name"`
+	Avatar    []byte    `db:"avatar"`
+	Email     *string   `db:"email"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+const (
+	selectUser = "SELECT `id`, `name`, `avatar`, `email`, `created_at` FROM `users` WHERE `id` = ?"
+	insertUser = "INSERT INTO `users` (`name`) VALUES (?)"
+)
+
+// run is the code under test, run against the recorder then the replayer
+func run(ctx context.Context, d *db.DB) (user, error) {
+	err := d.WithTransaction(ctx, func(txCtx context.Context) error {
+		_, err := d.Exec(txCtx, insertUser, "alice")
+		return err
+	})
+	if err != nil {
+		return user{}, err
+	}
+
+	err = d.WithTransaction(ctx, func(txCtx context.Context) error {
+		if _, err := d.Exec(txCtx, insertUser, "alice"); err != nil {
+			return err
+		}
+		return errors.New("duplicate")
+	})
+	if err == nil || err.Error() != "duplicate" {
+		return user{}, fmt.Errorf("unexpected error: %v", err)
+	}
+
+	return db.Get[user](ctx, d, selectUser, 1)
+}
+
+func TestRecordReplay(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "testdata", "users.json")
+	created := time.Date(2022, 5, 1, 12, 30, 0, 0, time.UTC)
+
+	// record
+	connector, mock := newMockConnector(t)
+	mock.ExpectBegin()
+	mock.ExpectExec(insertUser).WithArgs("alice").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(insertUser).WithArgs("alice").WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectRollback()
+	mock.ExpectQuery(selectUser).WithArgs(1).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "name", "avatar", "email", "created_at"}).
+			AddRow(int64(1), "alice", []byte{0xca, 0xfe}, nil, created))
+	mock.ExpectClose()
+
+	recorder := NewRecorder(connector, path)
+	d, err := db.New(recorder)
+	assert.NoError(t, err)
+	recorded, err := run(ctx, d)
+	assert.NoError(t, err)
+	d.Close()
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.NoError(t, recorder.Save())
+	assert.Len(t, recorder.Interactions(), 7)
+
+	// replay
+	replayer, err := NewReplayer(path)
+	assert.NoError(t, err)
+	d, err = db.New(replayer)
+	assert.NoError(t, err)
+	replayed, err := run(ctx, d)
+	assert.NoError(t, err)
+	d.Close()
+	assert.NoError(t, replayer.ExpectationsWereMet())
+
+	assert.Equal(t, recorded, replayed)
+	assert.Equal(t, user{ID: 1, Name: "alice", Avatar: []byte{0xca, 0xfe}, CreatedAt: created}, replayed)
+}
+
+func TestReplayUnexpected(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "users.json")
+	assert.NoError(t, writeGolden(path, []Interaction{
+		{Kind: KindExec, Query: insertUser, Args: []Value{{Type: typeString, Value: []byte(`"alice"`)}}, RowsAffected: 1},
+		{Kind: KindQuery, Query: selectUser, Error: &Error{Message: "deadlock", State: "40P01"}},
+	}))
+
+	replayer, err := NewReplayer(path)
+	assert.NoError(t, err)
+	d, err := db.New(replayer, db.WithAutocommit())
+	assert.NoError(t, err)
+	defer d.Close()
+
+	// the arguments are part of the interaction
+	_, err = d.Exec(ctx, insertUser, "bob")
+	assert.ErrorIs(t, err, ErrUnexpected)
+	assert.Contains(t, err.Error(), `string("bob")`)
+
+	res, err := d.Exec(ctx, insertUser, "alice")
+	assert.NoError(t, err)
+	affected, _ := res.RowsAffected()
+	assert.Equal(t, int64(1), affected)
+
+	// the recorded errors keep their SQL state
+	_, err = d.Query(ctx, selectUser)
+	assert.True(t, db.IsRetryable(err))
+
+	_, err = d.Query(ctx, selectUser)
+	assert.ErrorIs(t, err, ErrUnexpected)
+
+	// the first unexpected interaction is reported, even if it has been ignored
+	err = replayer.ExpectationsWereMet()
+	assert.ErrorIs(t, err, ErrUnexpected)
+	assert.Contains(t, err.Error(), `string("bob")`)
+}
+
+func TestRecordResultErrors(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "users.json")
+
+	// record
+	connector, mock := newMockConnector(t)
+	mock.ExpectExec(insertUser).WithArgs("alice").WillReturnResult(sqlmock.NewErrorResult(errors.New("not supported")))
+
+	recorder := NewRecorder(connector, path)
+	d, err := db.New(recorder, db.WithAutocommit())
+	assert.NoError(t, err)
+	_, err = d.Exec(ctx, insertUser, "alice")
+	assert.NoError(t, err)
+	d.Close()
+	assert.NoError(t, recorder.Save())
+
+	// replay
+	replayer, err := NewReplayer(path)
+	assert.NoError(t, err)
+	d, err = db.New(replayer, db.WithAutocommit())
+	assert.NoError(t, err)
+	res, err := d.Exec(ctx, insertUser, "alice")
+	assert.NoError(t, err)
+	_, err = res.LastInsertId()
+	assert.EqualError(t, err, "not supported")
+	_, err = res.RowsAffected()
+	assert.EqualError(t, err, "not supported")
+	d.Close()
+	assert.NoError(t, replayer.ExpectationsWereMet())
+}
+
+func TestReplayRemaining(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+	assert.NoError(t, writeGolden(path, []Interaction{{Kind: KindBegin}, {Kind: KindCommit}}))
+
+	replayer, err := NewReplayer(path)
+	assert.NoError(t, err)
+	assert.EqualError(t, replayer.ExpectationsWereMet(),
+		fmt.Sprintf("dbtest: 2 interactions of %s have not been replayed, the next one is begin", path))
+}
+
+func TestOpen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "users.json")
+
+	t.Run("record", func(t *testing.T) {
+		t.Setenv(recordEnv, "1")
+		connector, mock := newMockConnector(t)
+		mock.ExpectExec(insertUser).WithArgs("alice").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		d, err := db.New(Open(t, path, func() (driver.Connector, error) { return connector, nil }), db.WithAutocommit())
+		assert.NoError(t, err)
+		_, err = d.Exec(ctx, insertUser, "alice")
+		assert.NoError(t, err)
+		d.Close()
+	})
+
+	t.Run("replay", func(t *testing.T) {
+		d, err := db.New(Open(t, path, func() (driver.Connector, error) {
+			return nil, errors.New("no database")
+		}), db.WithAutocommit())
+		assert.NoError(t, err)
+		_, err = d.Exec(ctx, insertUser, "alice")
+		assert.NoError(t, err)
+		d.Close()
+	})
+}
+
+func TestReplayMatchers(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "events.json")
+	insert := "INSERT INTO `events` (`id`, `name`, `created_at`) VALUES (?, ?, ?)"
+	recorded := time.Date(2022, 5, 1, 12, 30, 0, 0, time.UTC)
+	args := make([]Value, 3)
+	for n, v := range []driver.Value{"6f1c", "created", recorded} {
+		var err error
+		args[n], err = newValue(v)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, writeGolden(path, []Interaction{
+		{Kind: KindExec, Query: insert, Args: args, RowsAffected: 1},
+		{Kind: KindExec, Query: insert, Args: args, RowsAffected: 1},
+	}))
+
+	replayer, err := NewReplayer(path, AnyTime, IgnoreArg(insert, 0))
+	assert.NoError(t, err)
+	d, err := db.New(replayer, db.WithAutocommit())
+	assert.NoError(t, err)
+	defer d.Close()
+
+	// the current time and the random IDs are matched
+	_, err = d.Exec(ctx, insert, "9b2e", "created", time.Now())
+	assert.NoError(t, err)
+
+	// the other arguments are still compared
+	_, err = d.Exec(ctx, insert, "9b2e", "deleted", time.Now())
+	assert.ErrorIs(t, err, ErrUnexpected)
+	_, err = d.Exec(ctx, insert, "9b2e", "created", "now")
+	assert.ErrorIs(t, err, ErrUnexpected)
+}
+
+// point is an argument accepted by the converter of the driver, but not a driver value
+type point struct {
+	X, Y int
+}
+
+type pointConverter struct{}
+
+func (pointConverter) ConvertValue(v interface{}) (driver.Value, error) {
+	if p, ok := v.(point); ok {
+		return p, nil
+	}
+	return driver.DefaultParameterConverter.ConvertValue(v)
+}
+
+func TestRecordDriverValues(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "places.json")
+	insert := "INSERT INTO `places` (`location`) VALUES (?)"
+
+	// record
+	dsn := fmt.Sprintf("%s_%d", t.Name(), time.Now().UnixNano())
+	mockdb, mock, err := sqlmock.NewWithDSN(dsn,
+		sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual), sqlmock.ValueConverterOption(pointConverter{}))
+	assert.NoError(t, err)
+	mock.ExpectExec(insert).WithArgs(point{X: 1, Y: 2}).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	recorder := NewRecorder(&mockConnector{dsn: dsn, driver: mockdb.Driver()}, path)
+	d, err := db.New(recorder, db.WithAutocommit())
+	assert.NoError(t, err)
+	_, err = d.Exec(ctx, insert, point{X: 1, Y: 2})
+	assert.NoError(t, err)
+	d.Close()
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.NoError(t, recorder.Save())
+	assert.Equal(t, `dbtest.point("{1 2}")`, recorder.Interactions()[0].Args[0].String())
+
+	// replay
+	replayer, err := NewReplayer(path)
+	assert.NoError(t, err)
+	d, err = db.New(replayer, db.WithAutocommit())
+	assert.NoError(t, err)
+	_, err = d.Exec(ctx, insert, point{X: 1, Y: 2})
+	assert.NoError(t, err)
+	d.Close()
+	assert.NoError(t, replayer.ExpectationsWereMet())
+}
@@ -0,0 +1,226 @@
+package dbtest
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Kind is the kind of an interaction with the database
+type Kind string
+
+const (
+	// KindQuery is a query returning rows
+	KindQuery Kind = "query"
+	// KindExec is a statement not returning rows
+	KindExec Kind = "exec"
+	// KindBegin is the start of a transaction
+	KindBegin Kind = "begin"
+	// KindCommit is the commit of a transaction
+	KindCommit Kind = "commit"
+	// KindRollback is the rollback of a transaction
+	KindRollback Kind = "rollback"
+)
+
+// Interaction is an operation on the database, with its outcome
+type Interaction struct {
+	Kind  Kind    `json:"kind"`
+	Query string  `json:"query,omitempty"`
+	Args  []Value `json:"args,omitempty"`
+
+	// Columns and Rows are the result set of a query
+	Columns []string  `json:"columns,omitempty"`
+	Rows    [][]Value `json:"rows,omitempty"`
+
+	// LastInsertID and RowsAffected are the result of a statement,
+	// LastInsertIDError and RowsAffectedError their errors if the driver does not support them
+	LastInsertID      int64  `json:"last_insert_id,omitempty"`
+	LastInsertIDError *Error `json:"last_insert_id_error,omitempty"`
+	RowsAffected      int64  `json:"rows_affected,omitempty"`
+	RowsAffectedError *Error `json:"rows_affected_error,omitempty"`
+
+	// Error is the error of the operation, if any
+	Error *Error `json:"error,omitempty"`
+	// RowsError is the error reading the result set of a query, after its rows
+	RowsError *Error `json:"rows_error,omitempty"`
+}
+
+// Error is a recorded error, which keeps the SQL state of the original one
+type Error struct {
+	Message string `json:"message"`
+	State   string `json:"sql_state,omitempty"`
+}
+
+// Error returns the message of the recorded error
+func (e *Error) Error() string { return e.Message }
+
+// SQLState returns the SQL state of the recorded error, empty if it had none
+func (e *Error) SQLState() string { return e.State }
+
+// newError records an error
+func newError(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	e := &Error{Message: err.Error()}
+	var stateErr interface{ SQLState() string }
+	if errors.As(err, &stateErr) {
+		e.State = stateErr.SQLState()
+	}
+	return e
+}
+
+// err returns the recorded error as an error, nil if there is none
+func (e *Error) err() error {
+	if e == nil {
+		return nil
+	}
+	return e
+}
+
+// Value is a driver value with its type, so that it is replayed as it was recorded
+type Value struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// value types
+const (
+	typeNull    = "null"
+	typeInt64   = "int64"
+	typeFloat64 = "float64"
+	typeBool    = "bool"
+	typeString  = "string"
+	typeBytes   = "bytes"
+	typeTime    = "time"
+)
+
+// newValue records a driver value
+func newValue(v driver.Value) (Value, error) {
+	var t string
+	switch v := v.(type) {
+	case nil:
+		return Value{Type: typeNull}, nil
+	case int64:
+		t = typeInt64
+	case float64:
+		t = typeFloat64
+	case bool:
+		t = typeBool
+	case string:
+		t = typeString
+	case []byte:
+		t = typeBytes
+	case time.Time:
+		t = typeTime
+		// strip the monotonic clock, which is not a value
+		v = v.Round(0)
+	default:
+		// the other values accepted by the driver are recorded as their text
+		raw, err := json.Marshal(fmt.Sprint(v))
+		return Value{Type: fmt.Sprintf("%T", v), Value: raw}, err
+	}
+
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return Value{}, err
+	}
+	return Value{Type: t, Value: raw}, nil
+}
+
+// driverValue returns the recorded driver value
+func (v Value) driverValue() (driver.Value, error) {
+	var err error
+	switch v.Type {
+	case typeNull:
+		return nil, nil
+	case typeInt64:
+		var i int64
+		err = json.Unmarshal(v.Value, &i)
+		return i, err
+	case typeFloat64:
+		var f float64
+		err = json.Unmarshal(v.Value, &f)
+		return f, err
+	case typeBool:
+		var b bool
+		err = json.Unmarshal(v.Value, &b)
+		return b, err
+	case typeString:
+		var s string
+		err = json.Unmarshal(v.Value, &s)
+		return s, err
+	case typeBytes:
+		var b []byte
+		err = json.Unmarshal(v.Value, &b)
+		if b == nil && err == nil {
+			b = []byte{}
+		}
+		return b, err
+	case typeTime:
+		var t time.Time
+		err = json.Unmarshal(v.Value, &t)
+		return t, err
+	default:
+		// the values of the other types are replayed as their text
+		var s string
+		err = json.Unmarshal(v.Value, &s)
+		return s, err
+	}
+}
+
+// String returns the value as it is written in the golden file
+func (v Value) String() string {
+	if v.Type == typeNull {
+		return "NULL"
+	}
+	return fmt.Sprintf("%s(%s)", v.Type, v.Value)
+}
+
+// newValues records the arguments of an operation
+func newValues(args []driver.NamedValue) ([]Value, error) {
+	values := make([]Value, 0, len(args))
+	for _, arg := range args {
+		v, err := newValue(arg.Value)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// golden is the content of a golden file
+type golden struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// readGolden reads the interactions of a golden file
+func readGolden(path string) ([]Interaction, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var g golden
+	if err := json.Unmarshal(b, &g); err != nil {
+		return nil, fmt.Errorf("dbtest: malformed golden file %s: %w", path, err)
+	}
+	return g.Interactions, nil
+}
+
+// writeGolden writes the interactions into a golden file, creating its directory
+func writeGolden(path string, interactions []Interaction) error {
+	b, err := json.MarshalIndent(golden{Interactions: interactions}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0o644)
+}
@@ -0,0 +1,281 @@
+package dbtest
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+)
+
+// Recorder is a driver.Connector recording the interactions with the database of another connector.
+// The result sets are read as a whole when the queries are executed.
+type Recorder struct {
+	connector driver.Connector
+	path      string
+
+	mu           sync.Mutex
+	interactions []Interaction
+}
+
+// NewRecorder creates a new recorder of the connector, saving the interactions into the golden file
+func NewRecorder(connector driver.Connector, path string) *Recorder {
+	return &Recorder{connector: connector, path: path}
+}
+
+// Connect implements the driver.Connector interface
+func (r *Recorder) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := r.connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &recordConn{conn: conn, recorder: r}, nil
+}
+
+// Driver implements the driver.Connector interface
+func (r *Recorder) Driver() driver.Driver {
+	return r.connector.Driver()
+}
+
+// Interactions returns the interactions recorded so far
+func (r *Recorder) Interactions() []Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Interaction{}, r.interactions...)
+}
+
+// Save writes the interactions recorded so far into the golden file
+func (r *Recorder) Save() error {
+	return writeGolden(r.path, r.Interactions())
+}
+
+func (r *Recorder) record(i Interaction) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.interactions = append(r.interactions, i)
+}
+
+// recordConn is a connection recording the interactions of the connection it wraps
+type recordConn struct {
+	conn     driver.Conn
+	recorder *Recorder
+}
+
+var (
+	_ driver.ConnBeginTx        = (*recordConn)(nil)
+	_ driver.ConnPrepareContext = (*recordConn)(nil)
+	_ driver.ExecerContext      = (*recordConn)(nil)
+	_ driver.QueryerContext     = (*recordConn)(nil)
+	_ driver.NamedValueChecker  = (*recordConn)(nil)
+	_ driver.SessionResetter    = (*recordConn)(nil)
+)
+
+func (c *recordConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext returns a statement executing the query on the connection,
+// so that its executions are recorded like the others
+func (c *recordConn) PrepareContext(_ context.Context, query string) (driver.Stmt, error) {
+	return &stmt{conn: c, query: query}, nil
+}
+
+func (c *recordConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *recordConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *recordConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	var tx driver.Tx
+	var err error
+	if b, ok := c.conn.(driver.ConnBeginTx); ok {
+		tx, err = b.BeginTx(ctx, opts)
+	} else {
+		tx, err = c.conn.Begin() //nolint:staticcheck // the fallback of the drivers without BeginTx
+	}
+
+	c.recorder.record(Interaction{Kind: KindBegin, Error: newError(err)})
+	if err != nil {
+		return nil, err
+	}
+	return &recordTx{tx: tx, recorder: c.recorder}, nil
+}
+
+func (c *recordConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	values, err := newValues(args)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.exec(ctx, query, args)
+	i := Interaction{Kind: KindExec, Query: query, Args: values, Error: newError(err)}
+	if err == nil {
+		var rerr error
+		i.LastInsertID, rerr = res.LastInsertId()
+		i.LastInsertIDError = newError(rerr)
+		i.RowsAffected, rerr = res.RowsAffected()
+		i.RowsAffectedError = newError(rerr)
+	}
+	c.recorder.record(i)
+	return res, err
+}
+
+func (c *recordConn) exec(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if e, ok := c.conn.(driver.ExecerContext); ok {
+		res, err := e.ExecContext(ctx, query, args)
+		if !errors.Is(err, driver.ErrSkip) {
+			return res, err
+		}
+	}
+
+	s, err := c.prepare(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	if e, ok := s.(driver.StmtExecContext); ok {
+		return e.ExecContext(ctx, args)
+	}
+	return s.Exec(namedToValues(args)) //nolint:staticcheck // the fallback of the drivers without ExecContext
+}
+
+func (c *recordConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	values, err := newValues(args)
+	if err != nil {
+		return nil, err
+	}
+
+	i := Interaction{Kind: KindQuery, Query: query, Args: values}
+	rows, err := c.query(ctx, query, args)
+	if err != nil {
+		i.Error = newError(err)
+		c.recorder.record(i)
+		return nil, err
+	}
+
+	// read the whole result set, it is replayed from the recording
+	if err := readRows(rows, &i); err != nil {
+		return nil, err
+	}
+	c.recorder.record(i)
+	return newRows(i), nil
+}
+
+func (c *recordConn) query(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if q, ok := c.conn.(driver.QueryerContext); ok {
+		rows, err := q.QueryContext(ctx, query, args)
+		if !errors.Is(err, driver.ErrSkip) {
+			return rows, err
+		}
+	}
+
+	s, err := c.prepare(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows driver.Rows
+	if q, ok := s.(driver.StmtQueryContext); ok {
+		rows, err = q.QueryContext(ctx, args)
+	} else {
+		rows, err = s.Query(namedToValues(args)) //nolint:staticcheck // the fallback of the drivers without QueryContext
+	}
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return &stmtRows{Rows: rows, stmt: s}, nil
+}
+
+func (c *recordConn) prepare(ctx context.Context, query string) (driver.Stmt, error) {
+	if p, ok := c.conn.(driver.ConnPrepareContext); ok {
+		return p.PrepareContext(ctx, query)
+	}
+	return c.conn.Prepare(query)
+}
+
+// CheckNamedValue converts the arguments like the connection it wraps
+func (c *recordConn) CheckNamedValue(v *driver.NamedValue) error {
+	if checker, ok := c.conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(v)
+	}
+	return driver.ErrSkip
+}
+
+func (c *recordConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+// readRows reads and closes the rows into the interaction
+func readRows(rows driver.Rows, i *Interaction) error {
+	defer rows.Close()
+
+	i.Columns = rows.Columns()
+	dest := make([]driver.Value, len(i.Columns))
+	for {
+		err := rows.Next(dest)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			i.RowsError = newError(err)
+			break
+		}
+
+		row := make([]Value, len(dest))
+		for n, v := range dest {
+			if row[n], err = newValue(v); err != nil {
+				return err
+			}
+		}
+		i.Rows = append(i.Rows, row)
+	}
+	return nil
+}
+
+func namedToValues(args []driver.NamedValue) []driver.Value {
+	values := make([]driver.Value, len(args))
+	for n, arg := range args {
+		values[n] = arg.Value
+	}
+	return values
+}
+
+// stmtRows are rows closing their statement
+type stmtRows struct {
+	driver.Rows
+	stmt driver.Stmt
+}
+
+func (r *stmtRows) Close() error {
+	err := r.Rows.Close()
+	if serr := r.stmt.Close(); err == nil {
+		err = serr
+	}
+	return err
+}
+
+// recordTx is a transaction recording its end
+type recordTx struct {
+	tx       driver.Tx
+	recorder *Recorder
+}
+
+func (t *recordTx) Commit() error {
+	err := t.tx.Commit()
+	t.recorder.record(Interaction{Kind: KindCommit, Error: newError(err)})
+	return err
+}
+
+func (t *recordTx) Rollback() error {
+	err := t.tx.Rollback()
+	t.recorder.record(Interaction{Kind: KindRollback, Error: newError(err)})
+	return err
+}
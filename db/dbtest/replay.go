@@ -0,0 +1,256 @@
+package dbtest
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ErrUnexpected is returned when an operation does not match the next recorded interaction
+var ErrUnexpected = errors.New("dbtest: unexpected interaction")
+
+// Matcher reports whether the argument n of a replayed query matches its recorded value,
+// which is not the same. It relaxes the comparison of the arguments which change from one run
+// to another, like the current time or random IDs.
+type Matcher func(query string, n int, recorded, replayed Value) bool
+
+// AnyTime matches any time argument with a recorded time
+func AnyTime(_ string, _ int, recorded, replayed Value) bool {
+	return recorded.Type == typeTime && replayed.Type == typeTime
+}
+
+// IgnoreArg matches any value of the argument n, counted from 0, of a query
+func IgnoreArg(query string, n int) Matcher {
+	return func(q string, i int, _, _ Value) bool {
+		return q == query && i == n
+	}
+}
+
+// Replayer is a driver.Connector replaying the interactions of a golden file, without a database.
+// The interactions are expected in the order they have been recorded, on any connection,
+// with the same arguments unless a matcher accepts them.
+type Replayer struct {
+	path     string
+	matchers []Matcher
+
+	mu           sync.Mutex
+	interactions []Interaction
+	next         int
+	// err is the first unexpected operation, reported even if the code under test ignores it
+	err error
+}
+
+// NewReplayer creates a new replayer of the interactions of the golden file
+func NewReplayer(path string, matchers ...Matcher) (*Replayer, error) {
+	interactions, err := readGolden(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Replayer{path: path, matchers: matchers, interactions: interactions}, nil
+}
+
+// Connect implements the driver.Connector interface
+func (r *Replayer) Connect(context.Context) (driver.Conn, error) {
+	return &replayConn{replayer: r}, nil
+}
+
+// Driver implements the driver.Connector interface
+func (r *Replayer) Driver() driver.Driver {
+	return replayDriver{replayer: r}
+}
+
+// ExpectationsWereMet returns the first unexpected operation,
+// or an error if some recorded interactions have not been replayed
+func (r *Replayer) ExpectationsWereMet() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return r.err
+	}
+	if remaining := len(r.interactions) - r.next; remaining > 0 {
+		return fmt.Errorf("dbtest: %d interactions of %s have not been replayed, the next one is %s",
+			remaining, r.path, describe(&r.interactions[r.next]))
+	}
+	return nil
+}
+
+// replay returns the next recorded interaction, if it matches the operation
+func (r *Replayer) replay(kind Kind, query string, args []driver.NamedValue) (*Interaction, error) {
+	values, err := newValues(args)
+	if err != nil {
+		return nil, err
+	}
+	op := &Interaction{Kind: kind, Query: query, Args: values}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.next >= len(r.interactions) {
+		return nil, r.unexpected(fmt.Errorf("%w: %s, all the interactions of %s have been replayed",
+			ErrUnexpected, describe(op), r.path))
+	}
+
+	i := &r.interactions[r.next]
+	if i.Kind != op.Kind || i.Query != op.Query || !r.sameValues(op.Query, i.Args, op.Args) {
+		return nil, r.unexpected(fmt.Errorf("%w: %s, expected %s", ErrUnexpected, describe(op), describe(i)))
+	}
+	r.next++
+	return i, nil
+}
+
+// unexpected keeps the first unexpected operation
+func (r *Replayer) unexpected(err error) error {
+	if r.err == nil {
+		r.err = err
+	}
+	return err
+}
+
+// sameValues reports whether the replayed arguments of a query match the recorded ones
+func (r *Replayer) sameValues(query string, recorded, replayed []Value) bool {
+	if len(recorded) != len(replayed) {
+		return false
+	}
+	for n := range recorded {
+		if !r.sameValue(query, n, recorded[n], replayed[n]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Replayer) sameValue(query string, n int, recorded, replayed Value) bool {
+	if recorded.Type == replayed.Type && bytes.Equal(recorded.Value, replayed.Value) {
+		return true
+	}
+	for _, m := range r.matchers {
+		if m(query, n, recorded, replayed) {
+			return true
+		}
+	}
+	return false
+}
+
+// describe describes an interaction in the errors
+func describe(i *Interaction) string {
+	if i.Query == "" {
+		return string(i.Kind)
+	}
+
+	args := make([]string, len(i.Args))
+	for n, arg := range i.Args {
+		args[n] = arg.String()
+	}
+	return fmt.Sprintf("%s %q with args [%s]", i.Kind, i.Query, strings.Join(args, ", "))
+}
+
+// replayDriver opens connections to the replayer
+type replayDriver struct {
+	replayer *Replayer
+}
+
+func (d replayDriver) Open(string) (driver.Conn, error) {
+	return &replayConn{replayer: d.replayer}, nil
+}
+
+// replayConn is a connection replaying the recorded interactions
+type replayConn struct {
+	replayer *Replayer
+}
+
+var (
+	_ driver.ConnBeginTx        = (*replayConn)(nil)
+	_ driver.ConnPrepareContext = (*replayConn)(nil)
+	_ driver.ExecerContext      = (*replayConn)(nil)
+	_ driver.QueryerContext     = (*replayConn)(nil)
+	_ driver.NamedValueChecker  = (*replayConn)(nil)
+)
+
+func (c *replayConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *replayConn) PrepareContext(_ context.Context, query string) (driver.Stmt, error) {
+	return &stmt{conn: c, query: query}, nil
+}
+
+func (c *replayConn) Close() error {
+	return nil
+}
+
+// CheckNamedValue accepts the arguments which are not driver values, as the recorded driver may have,
+// they are compared by their text
+func (c *replayConn) CheckNamedValue(v *driver.NamedValue) error {
+	if value, err := driver.DefaultParameterConverter.ConvertValue(v.Value); err == nil {
+		v.Value = value
+	}
+	return nil
+}
+
+func (c *replayConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *replayConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	i, err := c.replayer.replay(KindBegin, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := i.Error.err(); err != nil {
+		return nil, err
+	}
+	return &replayTx{replayer: c.replayer}, nil
+}
+
+func (c *replayConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	i, err := c.replayer.replay(KindExec, query, args)
+	if err != nil {
+		return nil, err
+	}
+	if err := i.Error.err(); err != nil {
+		return nil, err
+	}
+	return result{
+		lastInsertID:    i.LastInsertID,
+		lastInsertIDErr: i.LastInsertIDError.err(),
+		rowsAffected:    i.RowsAffected,
+		rowsAffectedErr: i.RowsAffectedError.err(),
+	}, nil
+}
+
+func (c *replayConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	i, err := c.replayer.replay(KindQuery, query, args)
+	if err != nil {
+		return nil, err
+	}
+	if err := i.Error.err(); err != nil {
+		return nil, err
+	}
+	return newRows(*i), nil
+}
+
+// replayTx is a transaction replaying its end
+type replayTx struct {
+	replayer *Replayer
+}
+
+func (t *replayTx) Commit() error {
+	i, err := t.replayer.replay(KindCommit, "", nil)
+	if err != nil {
+		return err
+	}
+	return i.Error.err()
+}
+
+func (t *replayTx) Rollback() error {
+	i, err := t.replayer.replay(KindRollback, "", nil)
+	if err != nil {
+		return err
+	}
+	return i.Error.err()
+}
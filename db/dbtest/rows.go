@@ -0,0 +1,94 @@
+package dbtest
+
+import (
+	"context"
+	"database/sql/driver"
+	"io"
+)
+
+// conn is a connection executing the statements itself
+type conn interface {
+	driver.ExecerContext
+	driver.QueryerContext
+}
+
+// stmt is a statement executed by its connection
+type stmt struct {
+	conn  conn
+	query string
+}
+
+var (
+	_ driver.StmtExecContext  = (*stmt)(nil)
+	_ driver.StmtQueryContext = (*stmt)(nil)
+)
+
+func (s *stmt) Close() error  { return nil }
+func (s *stmt) NumInput() int { return -1 }
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), valuesToNamed(args))
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.conn.ExecContext(ctx, s.query, args)
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), valuesToNamed(args))
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.conn.QueryContext(ctx, s.query, args)
+}
+
+func valuesToNamed(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for n, arg := range args {
+		named[n] = driver.NamedValue{Ordinal: n + 1, Value: arg}
+	}
+	return named
+}
+
+// rows are the recorded rows of a query
+type rows struct {
+	i    Interaction
+	next int
+}
+
+func newRows(i Interaction) *rows {
+	return &rows{i: i}
+}
+
+func (r *rows) Columns() []string { return r.i.Columns }
+func (r *rows) Close() error      { return nil }
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.next >= len(r.i.Rows) {
+		if r.i.RowsError != nil {
+			return r.i.RowsError
+		}
+		return io.EOF
+	}
+
+	for n, v := range r.i.Rows[r.next] {
+		value, err := v.driverValue()
+		if err != nil {
+			return err
+		}
+		dest[n] = value
+	}
+	r.next++
+	return nil
+}
+
+// result is the recorded result of a statement
+type result struct {
+	lastInsertID    int64
+	lastInsertIDErr error
+	rowsAffected    int64
+	rowsAffectedErr error
+}
+
+func (r result) LastInsertId() (int64, error) { return r.lastInsertID, r.lastInsertIDErr }
+func (r result) RowsAffected() (int64, error) { return r.rowsAffected, r.rowsAffectedErr }
@@ -0,0 +1,137 @@
+package dbtest
+
+import (
+	"strings"
+
+	"github.com/org39/gopkg/db"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+// Suite is a test suite of the code built on top of db.DB, backed by sqlmock.
+// Each test gets a new database and mock, and ends by asserting that the expectations of the mock
+// were met and that no transaction leaked. A suite overriding SetupTest or TearDownTest must call
+// the ones of Suite.
+//
+//	type UsersTestSuite struct {
+//		dbtest.Suite
+//		users *Users
+//	}
+//
+//	func (s *UsersTestSuite) SetupTest() {
+//		s.Dialect = db.Postgres
+//		s.Suite.SetupTest()
+//		s.users = NewUsers(s.DB)
+//	}
+type Suite struct {
+	suite.Suite
+
+	// DB is the database of the current test
+	DB *db.DB
+	// Mock is the mock of the database of the current test
+	Mock sqlmock.Sqlmock
+
+	// Dialect is the dialect of the database, set before SetupTest
+	Dialect db.Dialect
+	// QueryMatcher matches the expected queries, QueryMatcherEqual by default, set before SetupTest
+	QueryMatcher sqlmock.QueryMatcher
+}
+
+// SetupTest will be run before every test in the suite.
+func (s *Suite) SetupTest() {
+	matcher := s.QueryMatcher
+	if matcher == nil {
+		matcher = sqlmock.QueryMatcherEqual
+	}
+
+	mockdb, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(matcher))
+	if err != nil {
+		panic(err)
+	}
+
+	mock.MatchExpectationsInOrder(true)
+	s.DB = &db.DB{DB: mockdb, Dialect: s.Dialect}
+	s.Mock = mock
+}
+
+// TearDownTest will be run after every test in the suite.
+func (s *Suite) TearDownTest() {
+	s.AssertNoLeakedTransaction()
+	assert.NoError(s.T(), s.Mock.ExpectationsWereMet())
+	s.DB.Close()
+}
+
+// TxExpectation is an expected transaction, which has to be ended by Commit or Rollback
+type TxExpectation struct {
+	mock sqlmock.Sqlmock
+}
+
+// ExpectTx expects a transaction around the statements expected by fn
+func (s *Suite) ExpectTx(fn func(mock sqlmock.Sqlmock)) *TxExpectation {
+	s.Mock.ExpectBegin()
+	fn(s.Mock)
+	return &TxExpectation{mock: s.Mock}
+}
+
+// Commit expects the transaction to be committed
+func (e *TxExpectation) Commit() *sqlmock.ExpectedCommit {
+	return e.mock.ExpectCommit()
+}
+
+// Rollback expects the transaction to be rolled back
+func (e *TxExpectation) Rollback() *sqlmock.ExpectedRollback {
+	return e.mock.ExpectRollback()
+}
+
+// ExpectCommitted expects a committed transaction around the statements expected by fn
+func (s *Suite) ExpectCommitted(fn func(mock sqlmock.Sqlmock)) {
+	s.ExpectTx(fn).Commit()
+}
+
+// ExpectRolledBack expects a rolled back transaction around the statements expected by fn
+func (s *Suite) ExpectRolledBack(fn func(mock sqlmock.Sqlmock)) {
+	s.ExpectTx(fn).Rollback()
+}
+
+// AssertNoLeakedTransaction asserts that no transaction of the database is still open
+func (s *Suite) AssertNoLeakedTransaction() bool {
+	return AssertNoLeakedTransaction(s.T(), s.DB)
+}
+
+// AssertRolledBackOnPanic asserts that fn panics, and that the transactions it has started
+// have been rolled back, as expected by the mock
+func (s *Suite) AssertRolledBackOnPanic(fn func()) bool {
+	t := s.T()
+	t.Helper()
+
+	if !assert.Panics(t, fn) {
+		return false
+	}
+	ok := assert.NoError(t, s.Mock.ExpectationsWereMet(), "the transaction has not been rolled back")
+	return s.AssertNoLeakedTransaction() && ok
+}
+
+// AssertNoLeakedTransaction asserts that no transaction of the database is still open,
+// and that no connection of its pool is still in use, held by a transaction begun on the
+// underlying sql.DB or by rows which have not been closed
+func AssertNoLeakedTransaction(t assert.TestingT, d *db.DB) bool {
+	if h, ok := t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+
+	if open := d.OpenTransactions(); len(open) > 0 {
+		leaked := make([]string, len(open))
+		for i, info := range open {
+			leaked[i] = info.String()
+		}
+		return assert.Fail(t, "transactions leaked", "%d transactions are still open: %s",
+			len(open), strings.Join(leaked, ", "))
+	}
+
+	if inUse := d.DB.Stats().InUse; inUse > 0 {
+		return assert.Fail(t, "connections leaked", "%d connections are still in use", inUse)
+	}
+	return true
+}
@@ -0,0 +1,113 @@
+package dbtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/org39/gopkg/db"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+// fakeT records the failure of an assertion
+type fakeT struct {
+	msg string
+}
+
+func (t *fakeT) Errorf(format string, args ...interface{}) {
+	t.msg = fmt.Sprintf(format, args...)
+}
+
+type SuiteTestSuite struct {
+	Suite
+}
+
+// SetupTest will be run before every test in the suite.
+func (s *SuiteTestSuite) SetupTest() {
+	s.Dialect = db.Postgres
+	s.Suite.SetupTest()
+	s.DB.Rebind = true
+}
+
+// TestExpectTx will test the expected transactions, committed or rolled back.
+func (s *SuiteTestSuite) TestExpectTx() {
+	ctx := context.Background()
+	q := "UPDATE users SET name = $1 WHERE id = $2"
+
+	// mock
+	s.ExpectCommitted(func(mock sqlmock.Sqlmock) {
+		mock.ExpectExec(q).WithArgs("alice", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	})
+	s.ExpectRolledBack(func(mock sqlmock.Sqlmock) {
+		mock.ExpectExec(q).WithArgs("bob", 1).WillReturnError(errors.New("conflict"))
+	})
+	s.ExpectTx(func(sqlmock.Sqlmock) {}).Commit().WillReturnError(errors.New("commit failed"))
+
+	// test
+	_, err := s.DB.Exec(ctx, "UPDATE users SET name = ? WHERE id = ?", "alice", 1)
+	assert.NoError(s.T(), err)
+	_, err = s.DB.Exec(ctx, "UPDATE users SET name = ? WHERE id = ?", "bob", 1)
+	assert.EqualError(s.T(), err, "conflict")
+	err = s.DB.WithTransaction(ctx, func(context.Context) error { return nil })
+	assert.EqualError(s.T(), err, "commit failed")
+}
+
+// TestAssertRolledBackOnPanic will test the transaction rolled back on panic.
+func (s *SuiteTestSuite) TestAssertRolledBackOnPanic() {
+	ctx := context.Background()
+
+	// mock
+	s.ExpectRolledBack(func(sqlmock.Sqlmock) {})
+
+	// test
+	s.AssertRolledBackOnPanic(func() {
+		_ = s.DB.WithTransaction(ctx, func(context.Context) error {
+			panic("boom")
+		})
+	})
+}
+
+// TestAssertNoLeakedTransaction will test the transactions still open reported.
+func (s *SuiteTestSuite) TestAssertNoLeakedTransaction() {
+	ctx := context.Background()
+
+	// mock
+	s.ExpectCommitted(func(sqlmock.Sqlmock) {})
+	s.ExpectRolledBack(func(sqlmock.Sqlmock) {})
+
+	// test
+	started, release, done := make(chan struct{}), make(chan struct{}), make(chan error)
+	go func() {
+		done <- s.DB.WithTransaction(ctx, func(context.Context) error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	<-started
+
+	t := &fakeT{}
+	assert.False(s.T(), AssertNoLeakedTransaction(t, s.DB))
+	assert.Contains(s.T(), t.msg, "1 transactions are still open")
+
+	close(release)
+	assert.NoError(s.T(), <-done)
+	assert.True(s.T(), s.AssertNoLeakedTransaction())
+
+	// a transaction begun on the underlying database
+	tx, err := s.DB.DB.Begin()
+	assert.NoError(s.T(), err)
+	t = &fakeT{}
+	assert.False(s.T(), AssertNoLeakedTransaction(t, s.DB))
+	assert.Contains(s.T(), t.msg, "1 connections are still in use")
+	assert.NoError(s.T(), tx.Rollback())
+	assert.True(s.T(), s.AssertNoLeakedTransaction())
+}
+
+func TestSuite(t *testing.T) {
+	suite.Run(t, new(SuiteTestSuite))
+}
@@ -0,0 +1,64 @@
+package db
+
+import "fmt"
+
+// Dialect is the SQL dialect spoken by the database
+type Dialect string
+
+const (
+	// MySQL is the dialect of MySQL and MariaDB
+	MySQL Dialect = "mysql"
+	// Postgres is the dialect of PostgreSQL
+	Postgres Dialect = "postgres"
+	// SQLite is the dialect of SQLite
+	SQLite Dialect = "sqlite"
+	// SQLServer is the dialect of Microsoft SQL Server
+	SQLServer Dialect = "sqlserver"
+)
+
+// savepoint returns the statement that creates a savepoint
+func (d Dialect) savepoint(name string) string {
+	if d == SQLServer {
+		return fmt.Sprintf("SAVE TRANSACTION %s", name)
+	}
+	return fmt.Sprintf("SAVEPOINT %s", name)
+}
+
+// rollbackToSavepoint returns the statement that rolls back to a savepoint
+func (d Dialect) rollbackToSavepoint(name string) string {
+	if d == SQLServer {
+		return fmt.Sprintf("ROLLBACK TRANSACTION %s", name)
+	}
+	return fmt.Sprintf("ROLLBACK TO SAVEPOINT %s", name)
+}
+
+// releaseSavepoint returns the statement that releases a savepoint,
+// or an empty string if the dialect has no such statement
+func (d Dialect) releaseSavepoint(name string) string {
+	if d == SQLServer {
+		return ""
+	}
+	return fmt.Sprintf("RELEASE SAVEPOINT %s", name)
+}
+
+// placeholder returns the n-th (1-based) positional parameter placeholder
+func (d Dialect) placeholder(n int) string {
+	switch d {
+	case Postgres:
+		return fmt.Sprintf("$%d", n)
+	case SQLServer:
+		return fmt.Sprintf("@p%d", n)
+	default:
+		return "?"
+	}
+}
+
+// rebinds reports whether the ? placeholders are rewritten for the dialect, when the database rebinds them
+func (d Dialect) rebinds() bool {
+	return d == Postgres || d == SQLServer
+}
+
+// backslashEscapes reports whether a backslash escapes a quote in a string literal
+func (d Dialect) backslashEscapes() bool {
+	return d == MySQL
+}
@@ -0,0 +1,299 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+
+	"github.com/org39/gopkg/crypt"
+)
+
+// ErrNoKeyRing is returned when an encrypted column is used without the key ring of the database
+var ErrNoKeyRing = errors.New("db: no key ring for the encrypted columns")
+
+// WithKeyRing is a database option that sets the key ring of the encrypted columns
+func WithKeyRing(r *crypt.KeyRing) func(*DB) error {
+	return func(db *DB) error {
+		db.KeyRing = r
+		return nil
+	}
+}
+
+// Encrypted is a string stored encrypted in a binary column, with the key ring of the database.
+// It is encrypted by the database, not by itself: as an argument of Exec, Query, QueryRow and QueryRowx,
+// by value or by pointer, and decrypted when it is scanned by QueryRowx, Get, Select and Stream.
+// Used without the database, like with the rows of Query or QueryRow, nested in another driver.Valuer,
+// or with database/sql, its Value and Scan methods fail with ErrNoKeyRing.
+// A nullable encrypted column is scanned into a Null[Encrypted].
+//
+// Every encryption uses a random nonce, so an encrypted column can not be compared to an argument,
+// like in WHERE phone = ?: the rows are looked up by another column, like a keyed hash of the value.
+//
+// The ciphertext is authenticated with the ID of its key, but not bound to its table, column or row:
+// a ciphertext copied into another row or column is still decrypted. The values which must not be
+// swapped between rows have to be encrypted along with their key.
+type Encrypted string
+
+// Scan implements the sql.Scanner interface, the value is decrypted by the database
+func (e *Encrypted) Scan(interface{}) error {
+	return ErrNoKeyRing
+}
+
+// Value implements the driver.Valuer interface, the value is encrypted by the database
+func (e Encrypted) Value() (driver.Value, error) {
+	return nil, ErrNoKeyRing
+}
+
+// encryptArgs encrypts the Encrypted arguments with the key ring of the database
+func (db *DB) encryptArgs(args []interface{}) ([]interface{}, error) {
+	encrypted := args
+	for i, arg := range args {
+		var plaintext string
+		var valid bool
+		switch e := arg.(type) {
+		case Encrypted:
+			plaintext, valid = string(e), true
+		case *Encrypted:
+			if e != nil {
+				plaintext, valid = string(*e), true
+			}
+		case Null[Encrypted]:
+			plaintext, valid = string(e.V), e.Valid
+		case *Null[Encrypted]:
+			if e != nil {
+				plaintext, valid = string(e.V), e.Valid
+			}
+		default:
+			continue
+		}
+
+		var v driver.Value
+		if valid {
+			if db.KeyRing == nil {
+				return nil, ErrNoKeyRing
+			}
+			ciphertext, err := db.KeyRing.Encrypt([]byte(plaintext))
+			if err != nil {
+				return nil, err
+			}
+			v = ciphertext
+		}
+
+		// the arguments of the caller are left untouched
+		if &encrypted[0] == &args[0] {
+			encrypted = append([]interface{}(nil), args...)
+		}
+		encrypted[i] = v
+	}
+	return encrypted, nil
+}
+
+// decryptRows returns rows decrypting their encrypted columns with the key ring of the database
+func (db *DB) decryptRows(rows resultRows) resultRows {
+	if db.KeyRing == nil {
+		return rows
+	}
+	return decryptingRows{resultRows: rows, keys: db.KeyRing}
+}
+
+// decryptingRows are rows decrypting their encrypted columns
+type decryptingRows struct {
+	resultRows
+	keys *crypt.KeyRing
+}
+
+// Scan copies the columns of the current row into the values pointed at by dest
+func (r decryptingRows) Scan(dest ...interface{}) error {
+	return r.resultRows.Scan(decrypting(r.keys, dest)...)
+}
+
+// decrypting returns the destinations of a scan, the encrypted ones decrypting with a key ring
+func decrypting(r *crypt.KeyRing, dest []interface{}) []interface{} {
+	if r == nil {
+		return dest
+	}
+
+	wrapped := dest
+	for i, d := range dest {
+		var s interface{}
+		switch e := d.(type) {
+		case *Encrypted:
+			s = decrypter{keys: r, dest: e}
+		case *Null[Encrypted]:
+			s = nullDecrypter{keys: r, dest: e}
+		default:
+			continue
+		}
+
+		if &wrapped[0] == &dest[0] {
+			wrapped = append([]interface{}(nil), dest...)
+		}
+		wrapped[i] = s
+	}
+	return wrapped
+}
+
+// decrypter scans an encrypted column
+type decrypter struct {
+	keys *crypt.KeyRing
+	dest *Encrypted
+}
+
+// Scan implements the sql.Scanner interface
+func (d decrypter) Scan(value interface{}) error {
+	var ciphertext []byte
+	switch v := value.(type) {
+	case []byte:
+		ciphertext = v
+	case string:
+		ciphertext = []byte(v)
+	default:
+		return fmt.Errorf("db: can not scan %T into an encrypted column", value)
+	}
+
+	plaintext, err := d.keys.Decrypt(ciphertext)
+	if err != nil {
+		return err
+	}
+
+	*d.dest = Encrypted(plaintext)
+	return nil
+}
+
+// nullDecrypter scans a nullable encrypted column
+type nullDecrypter struct {
+	keys *crypt.KeyRing
+	dest *Null[Encrypted]
+}
+
+// Scan implements the sql.Scanner interface
+func (d nullDecrypter) Scan(value interface{}) error {
+	*d.dest = Null[Encrypted]{}
+	if value == nil {
+		return nil
+	}
+
+	if err := (decrypter{keys: d.keys, dest: &d.dest.V}).Scan(value); err != nil {
+		return err
+	}
+	d.dest.Valid = true
+	return nil
+}
+
+// ReEncrypt re-encrypts the values of an encrypted column which are not encrypted
+// with the current key of the key ring of the database, so that the previous keys can be retired.
+// The rows are walked by their key column on the primary database, bypassing the cache,
+// and updated in batches within transactions, a value changed concurrently is left untouched.
+// It returns the number of re-encrypted values.
+// The table and columns are written in the queries as is, and must not come from user input.
+func ReEncrypt(ctx context.Context, db *DB, table, keyColumn, column string, batchSize int) (int, error) {
+	if batchSize < 1 {
+		return 0, fmt.Errorf("db: invalid batch size %d", batchSize)
+	}
+	r := db.KeyRing
+	if r == nil {
+		return 0, ErrNoKeyRing
+	}
+
+	p := Pager{Columns: []SortColumn{{Name: keyColumn}}, Limit: batchSize}
+	query := fmt.Sprintf("SELECT %s, %s FROM %s", keyColumn, column, table)
+	update := fmt.Sprintf("UPDATE %s SET %s = ? WHERE %s = ? AND %s = ?", table, column, keyColumn, column)
+
+	// the rows are read from the primary, a replica or the cache could miss the latest writes
+	readCtx := UseRebind(UsePrimary(withoutCache(ctx)))
+
+	var c *cursor
+	var args []interface{}
+	count := 0
+	for {
+		rows, err := StreamFunc(readCtx, db, scanEncryptedRow, p.query(db.Dialect, query, c), args...)
+		if err != nil {
+			return count, err
+		}
+
+		var batch []encryptedRow
+		for rows.Next() {
+			batch = append(batch, rows.Value())
+		}
+		if err := rows.Err(); err != nil {
+			return count, err
+		}
+
+		// a batch is fetched with an extra row, to know if there is more after it
+		more := len(batch) > batchSize
+		if more {
+			batch = batch[:batchSize]
+		}
+
+		// the null values and the values encrypted with the current key are skipped
+		stale := make([]encryptedRow, 0, len(batch))
+		for _, row := range batch {
+			if id, err := crypt.KeyID(row.ciphertext); err == nil && id != r.Current() {
+				stale = append(stale, row)
+			}
+		}
+
+		if len(stale) > 0 {
+			n := 0
+			err := db.WithTransaction(ctx, func(txCtx context.Context) error {
+				var rerr error
+				n, rerr = reEncryptRows(txCtx, db, r, update, stale)
+				return rerr
+			})
+			if err != nil {
+				return count, err
+			}
+			count += n
+		}
+		if !more {
+			return count, nil
+		}
+
+		c, args = &cursor{}, []interface{}{batch[len(batch)-1].key}
+	}
+}
+
+// reEncryptRows re-encrypts the rows of a batch, it returns the number of updated rows
+func reEncryptRows(ctx context.Context, db *DB, r *crypt.KeyRing, update string, rows []encryptedRow) (int, error) {
+	n := 0
+	for _, row := range rows {
+		plaintext, err := r.Decrypt(row.ciphertext)
+		if err != nil {
+			return n, fmt.Errorf("db: can not decrypt the value of %v: %w", row.key, err)
+		}
+		ciphertext, err := r.Encrypt(plaintext)
+		if err != nil {
+			return n, err
+		}
+
+		res, err := db.Exec(UseRebind(ctx), update, ciphertext, row.key, row.ciphertext)
+		if err != nil {
+			return n, err
+		}
+		if affected, err := res.RowsAffected(); err == nil {
+			n += int(affected)
+		}
+	}
+
+	return n, nil
+}
+
+// encryptedRow is a row of an encrypted column, by key
+type encryptedRow struct {
+	key        interface{}
+	ciphertext []byte
+}
+
+func scanEncryptedRow(s Scanable) (encryptedRow, error) {
+	var row encryptedRow
+	if err := s.Scan(&row.key, &row.ciphertext); err != nil {
+		return row, err
+	}
+
+	// a key returned as text is compared as such
+	if b, ok := row.key.([]byte); ok {
+		row.key = string(b)
+	}
+	return row, nil
+}
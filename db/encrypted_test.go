@@ -0,0 +1,178 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/org39/gopkg/crypt"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestKeyRing(t *testing.T) *crypt.KeyRing {
+	r, err := crypt.NewKeyRing(map[uint32][]byte{
+		1: bytes.Repeat([]byte{1}, 32),
+		2: bytes.Repeat([]byte{2}, 32),
+	}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// ciphertextOf matches the ciphertexts of a key
+type ciphertextOf uint32
+
+func (k ciphertextOf) Match(v driver.Value) bool {
+	b, ok := v.([]byte)
+	if !ok {
+		return false
+	}
+	id, err := crypt.KeyID(b)
+	return err == nil && id == uint32(k)
+}
+
+// TestEncrypted will test the encrypted arguments and columns, with the key ring of the database.
+func (s *DBTestSuite) TestEncrypted() {
+	ctx := context.Background()
+	r := newTestKeyRing(s.T())
+	s.db.KeyRing = r
+	old, err := r.Encrypt([]byte("+33 6 12 34 56 78"))
+	assert.NoError(s.T(), err)
+	assert.NoError(s.T(), r.Use(2))
+	tampered := append([]byte(nil), old...)
+	tampered[len(tampered)-1] ^= 1
+
+	// mock
+	insert := "INSERT INTO `users` (`phone`, `national_id`) VALUES (?, ?)"
+	q := "SELECT `phone`, `national_id` FROM `users` WHERE `id` = ?"
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(insert).WithArgs(ciphertextOf(2), nil).WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectCommit()
+	s.mock.ExpectQuery(q).WithArgs(1).WillReturnRows(
+		sqlmock.NewRows([]string{"phone", "national_id"}).AddRow(old, old))
+	s.mock.ExpectQuery(q).WithArgs(1).WillReturnRows(
+		sqlmock.NewRows([]string{"phone", "national_id"}).AddRow(old, nil))
+	s.mock.ExpectQuery(q).WithArgs(1).WillReturnRows(
+		sqlmock.NewRows([]string{"phone", "national_id"}).AddRow(tampered, nil))
+
+	// test
+	args := []interface{}{Encrypted("+33 6 12 34 56 78"), Null[Encrypted]{}}
+	_, err = s.db.Exec(ctx, insert, args...)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), Encrypted("+33 6 12 34 56 78"), args[0])
+
+	// the values encrypted with a previous key are still decrypted
+	var phone Encrypted
+	var nationalID Null[Encrypted]
+	assert.NoError(s.T(), s.db.QueryRowx(ctx, q, 1).Scan(&phone, &nationalID))
+	assert.Equal(s.T(), Encrypted("+33 6 12 34 56 78"), phone)
+	assert.Equal(s.T(), NewNull(Encrypted("+33 6 12 34 56 78")), nationalID)
+
+	type user struct {
+		Phone      Encrypted       `db:"phone"`
+		NationalID Null[Encrypted] `db:"national_id"`
+	}
+	u, err := Get[user](ctx, s.db, q, 1)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), user{Phone: "+33 6 12 34 56 78"}, u)
+
+	// a tampered value is rejected
+	_, err = Get[user](ctx, s.db, q, 1)
+	assert.ErrorIs(s.T(), err, crypt.ErrMalformedCiphertext)
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+
+	// without the database
+	assert.ErrorIs(s.T(), phone.Scan(old), ErrNoKeyRing)
+	_, err = phone.Value()
+	assert.ErrorIs(s.T(), err, ErrNoKeyRing)
+	s.db.KeyRing = nil
+	s.mock.ExpectBegin()
+	s.mock.ExpectRollback()
+	_, err = s.db.Exec(ctx, insert, args...)
+	assert.ErrorIs(s.T(), err, ErrNoKeyRing)
+}
+
+// TestEncryptedContract will test the Encrypted values encrypted and decrypted by the database only.
+func (s *DBTestSuite) TestEncryptedContract() {
+	ctx := context.Background()
+	r := newTestKeyRing(s.T())
+	s.db.KeyRing = r
+	ciphertext, err := r.Encrypt([]byte("+33 6 12 34 56 78"))
+	assert.NoError(s.T(), err)
+
+	// mock
+	insert := "INSERT INTO `users` (`phone`, `national_id`) VALUES (?, ?)"
+	q := "SELECT `phone` FROM `users` WHERE `id` = ?"
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(insert).WithArgs(ciphertextOf(1), nil).WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectCommit()
+	s.mock.ExpectQuery(q).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"phone"}).AddRow(ciphertext))
+	s.mock.ExpectQuery(q).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"phone"}).AddRow(ciphertext))
+
+	// test
+	phone := Encrypted("+33 6 12 34 56 78")
+	_, err = s.db.Exec(ctx, insert, &phone, (*Null[Encrypted])(nil))
+	assert.NoError(s.T(), err)
+
+	// the rows of Query and QueryRow are not decrypted
+	rows, err := s.db.Query(ctx, q, 1)
+	assert.NoError(s.T(), err)
+	assert.True(s.T(), rows.Next())
+	assert.ErrorIs(s.T(), rows.Scan(&phone), ErrNoKeyRing)
+	assert.NoError(s.T(), rows.Close())
+	assert.ErrorIs(s.T(), s.db.QueryRow(ctx, q, 1).Scan(&phone), ErrNoKeyRing)
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+
+	// the same value is encrypted with a different nonce every time
+	a, err := s.db.encryptArgs([]interface{}{phone})
+	assert.NoError(s.T(), err)
+	b, err := s.db.encryptArgs([]interface{}{phone})
+	assert.NoError(s.T(), err)
+	assert.NotEqual(s.T(), a, b)
+}
+
+// TestReEncrypt will test the values re-encrypted with the current key.
+func (s *DBTestSuite) TestReEncrypt() {
+	ctx := context.Background()
+	r := newTestKeyRing(s.T())
+	s.db.KeyRing = r
+	s.db.Cache = NewMemoryCache(0)
+
+	encrypt := func(plaintext string) []byte {
+		b, err := r.Encrypt([]byte(plaintext))
+		if err != nil {
+			s.T().Fatal(err)
+		}
+		return b
+	}
+	first, third := encrypt("first"), encrypt("third")
+	assert.NoError(s.T(), r.Use(2))
+	second := encrypt("second")
+
+	// mock
+	base := "SELECT * FROM (SELECT id, phone FROM users) AS page"
+	update := "UPDATE users SET phone = ? WHERE id = ? AND phone = ?"
+	s.mock.ExpectQuery(base + " ORDER BY id LIMIT 3").WillReturnRows(
+		sqlmock.NewRows([]string{"id", "phone"}).AddRow(1, first).AddRow(2, second).AddRow(3, third),
+	)
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(update).WithArgs(ciphertextOf(2), 1, first).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+	s.mock.ExpectQuery(base + " WHERE (id) > (?) ORDER BY id LIMIT 3").WithArgs(2).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "phone"}).AddRow(3, third).AddRow(4, nil),
+	)
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(update).WithArgs(ciphertextOf(2), 3, third).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+
+	// test
+	n, err := ReEncrypt(UseCache(ctx, time.Minute), s.db, "users", "id", "phone", 2)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), 2, n)
+	assert.Zero(s.T(), s.db.Cache.(*MemoryCache).Len())
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
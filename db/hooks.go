@@ -0,0 +1,74 @@
+package db
+
+import "context"
+
+// AfterCommit registers a function run once the outermost transaction of the context
+// has committed. If the work of a nested transaction is rolled back to its savepoint,
+// the functions it registered are discarded.
+// Outside a transaction, the function is run immediately.
+func AfterCommit(ctx context.Context, fn func(context.Context)) {
+	t, ok := txFromContext(ctx)
+	if !ok {
+		fn(ctx)
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.afterCommit = append(t.afterCommit, fn)
+}
+
+// AfterRollback registers a function run once the outermost transaction of the context
+// has rolled back, or has failed to commit. If the work of a nested transaction is
+// rolled back to its savepoint, the functions it registered are run once the outermost
+// transaction has ended, whatever its outcome.
+// Outside a transaction, the function is never run.
+func AfterRollback(ctx context.Context, fn func(context.Context)) {
+	t, ok := txFromContext(ctx)
+	if !ok {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.afterRollback = append(t.afterRollback, fn)
+}
+
+// hookMark is the number of hooks registered when a savepoint is created
+type hookMark struct {
+	afterCommit   int
+	afterRollback int
+}
+
+// markHooks returns the current hook mark, before a savepoint is created
+func (t *transaction) markHooks() hookMark {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return hookMark{afterCommit: len(t.afterCommit), afterRollback: len(t.afterRollback)}
+}
+
+// rollbackHooks discards the hooks registered since the mark, when rolled back to a savepoint.
+// Their rollback hooks are kept to be run once the transaction has ended.
+func (t *transaction) rollbackHooks(mark hookMark) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rolledBack = append(t.rolledBack, t.afterRollback[mark.afterRollback:]...)
+	t.afterCommit = t.afterCommit[:mark.afterCommit]
+	t.afterRollback = t.afterRollback[:mark.afterRollback]
+}
+
+// runHooks runs the hooks of the ended transaction
+func (t *transaction) runHooks(ctx context.Context, committed bool) {
+	t.mu.Lock()
+	hooks := t.afterRollback
+	if committed {
+		hooks = t.afterCommit
+	}
+	hooks = append(hooks[:len(hooks):len(hooks)], t.rolledBack...)
+	t.afterCommit, t.afterRollback, t.rolledBack = nil, nil, nil
+	t.mu.Unlock()
+
+	for _, fn := range hooks {
+		fn(ctx)
+	}
+}
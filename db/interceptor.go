@@ -0,0 +1,139 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+)
+
+// OpKind is the kind of a database operation
+type OpKind string
+
+const (
+	// OpQuery is a query executed by Query
+	OpQuery OpKind = "query"
+	// OpQueryRow is a query executed by QueryRow or QueryRowx
+	OpQueryRow OpKind = "query_row"
+	// OpExec is a statement executed by Exec
+	OpExec OpKind = "exec"
+	// OpBegin is the start of a transaction
+	OpBegin OpKind = "begin"
+	// OpCommit is the commit of a transaction
+	OpCommit OpKind = "commit"
+	// OpRollback is the rollback of a transaction
+	OpRollback OpKind = "rollback"
+)
+
+// Op is a database operation seen by the interceptors
+type Op struct {
+	Kind OpKind
+	// Query and Args are the statement sent to the driver, once its arguments are bound,
+	// empty for the operations of a transaction. The Encrypted arguments are encrypted
+	// once the interceptors have run.
+	Query string
+	Args  []interface{}
+}
+
+// Interceptor observes and alters the database operations.
+type Interceptor interface {
+	// Before is called before the operation. It may rewrite the query and arguments of the operation,
+	// and fail it by returning an error. The returned context is used by the operation and passed to After.
+	// A rollback can not be failed, its error is ignored.
+	Before(ctx context.Context, op *Op) (context.Context, error)
+	// After is called once the operation has ended, with its error.
+	// It is only called if Before has succeeded.
+	After(ctx context.Context, op *Op, err error)
+}
+
+// InterceptorFuncs is an Interceptor made of functions, a nil function is skipped
+type InterceptorFuncs struct {
+	BeforeFunc func(ctx context.Context, op *Op) (context.Context, error)
+	AfterFunc  func(ctx context.Context, op *Op, err error)
+}
+
+// Before calls BeforeFunc
+func (f InterceptorFuncs) Before(ctx context.Context, op *Op) (context.Context, error) {
+	if f.BeforeFunc == nil {
+		return ctx, nil
+	}
+	return f.BeforeFunc(ctx, op)
+}
+
+// After calls AfterFunc
+func (f InterceptorFuncs) After(ctx context.Context, op *Op, err error) {
+	if f.AfterFunc != nil {
+		f.AfterFunc(ctx, op, err)
+	}
+}
+
+// WithInterceptors is a database option that adds interceptors to the database.
+// The Before hooks are called in order, the After hooks in reverse order.
+func WithInterceptors(interceptors ...Interceptor) func(*DB) error {
+	return func(db *DB) error {
+		db.Interceptors = append(db.Interceptors, interceptors...)
+		return nil
+	}
+}
+
+// intercept calls the Before hooks of the interceptors for an operation.
+// It returns the context of the operation, and a function calling the After hooks
+// with the error of the operation. If a Before hook fails, the After hooks of the
+// previous interceptors are called with its error, which is returned.
+func (db *DB) intercept(ctx context.Context, op *Op) (context.Context, func(error), error) {
+	if len(db.Interceptors) == 0 {
+		return ctx, func(error) {}, nil
+	}
+
+	ctxs := make([]context.Context, 0, len(db.Interceptors))
+	after := func(err error) {
+		for i := len(ctxs) - 1; i >= 0; i-- {
+			db.Interceptors[i].After(ctxs[i], op, err)
+		}
+	}
+
+	for _, interceptor := range db.Interceptors {
+		c, err := interceptor.Before(ctx, op)
+		if err != nil {
+			after(err)
+			return ctx, func(error) {}, err
+		}
+		ctx = c
+		ctxs = append(ctxs, ctx)
+	}
+
+	return ctx, after, nil
+}
+
+// begin starts a transaction, it returns the context of the transaction
+func (db *DB) begin(ctx context.Context, o txOptions) (context.Context, *sql.Tx, error) {
+	ctx, after, err := db.intercept(ctx, &Op{Kind: OpBegin})
+	if err != nil {
+		return ctx, nil, err
+	}
+
+	tx, err := db.DB.BeginTx(ctx, o.sqlTxOptions())
+	after(err)
+	return ctx, tx, err
+}
+
+// commit commits a transaction, or rolls it back if an interceptor fails the commit
+func (db *DB) commit(ctx context.Context, tx *sql.Tx) error {
+	ctx, after, err := db.intercept(ctx, &Op{Kind: OpCommit})
+	if err != nil {
+		_ = db.rollback(ctx, tx)
+		return err
+	}
+
+	err = tx.Commit()
+	after(err)
+	return err
+}
+
+// rollback rolls back a transaction
+func (db *DB) rollback(ctx context.Context, tx *sql.Tx) error {
+	// the transaction is rolled back even if an interceptor fails
+	_, after, _ := db.intercept(ctx, &Op{Kind: OpRollback})
+
+	err := tx.Rollback()
+	after(err)
+	return err
+}
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestInterceptors will test the operations observed and altered by the interceptors.
+func (s *DBTestSuite) TestInterceptors() {
+	ctx := context.Background()
+
+	var calls []string
+	recorder := func(name string) Interceptor {
+		return InterceptorFuncs{
+			BeforeFunc: func(ctx context.Context, op *Op) (context.Context, error) {
+				calls = append(calls, fmt.Sprintf("%s before %s", name, op.Kind))
+				return ctx, nil
+			},
+			AfterFunc: func(_ context.Context, op *Op, err error) {
+				calls = append(calls, fmt.Sprintf("%s after %s: %v", name, op.Kind, err))
+			},
+		}
+	}
+	tenant := InterceptorFuncs{
+		BeforeFunc: func(ctx context.Context, op *Op) (context.Context, error) {
+			switch {
+			case op.Kind == OpExec && op.Query == "DELETE FROM `users`":
+				return ctx, fmt.Errorf("unscoped delete")
+			case op.Kind == OpQueryRow:
+				op.Query += " AND `tenant_id` = ?"
+				op.Args = append(op.Args, 7)
+			}
+			return ctx, nil
+		},
+	}
+	s.db.Interceptors = []Interceptor{recorder("a"), recorder("b"), tenant}
+
+	// mock
+	q := "SELECT `name` FROM `users` WHERE `id` = ?"
+	s.mock.ExpectQuery(q+" AND `tenant_id` = ?").WithArgs(1, 7).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("alice"))
+	s.mock.ExpectBegin()
+	s.mock.ExpectRollback()
+
+	// test
+	var name string
+	assert.NoError(s.T(), s.db.QueryRow(ctx, q, 1).Scan(&name))
+	assert.Equal(s.T(), "alice", name)
+
+	_, err := s.db.Exec(ctx, "DELETE FROM `users`")
+	assert.EqualError(s.T(), err, "unscoped delete")
+
+	assert.Equal(s.T(), []string{
+		"a before query_row",
+		"b before query_row",
+		"b after query_row: <nil>",
+		"a after query_row: <nil>",
+		"a before begin",
+		"b before begin",
+		"b after begin: <nil>",
+		"a after begin: <nil>",
+		"a before exec",
+		"b before exec",
+		"b after exec: unscoped delete",
+		"a after exec: unscoped delete",
+		"a before rollback",
+		"b before rollback",
+		"b after rollback: <nil>",
+		"a after rollback: <nil>",
+	}, calls)
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
+
+// TestInterceptorQueryRowError will test the error of an interceptor returned by the row, as is by QueryRowx.
+func (s *DBTestSuite) TestInterceptorQueryRowError() {
+	ctx := context.Background()
+	errDenied := errors.New("denied")
+	s.db.Interceptors = []Interceptor{InterceptorFuncs{
+		BeforeFunc: func(ctx context.Context, op *Op) (context.Context, error) {
+			return ctx, errDenied
+		},
+	}}
+
+	q := "SELECT `name` FROM `users` WHERE `id` = ?"
+
+	// test
+	assert.ErrorIs(s.T(), s.db.QueryRow(ctx, q, 1).Scan(new(string)), errDenied)
+
+	row := s.db.QueryRowx(ctx, q, 1)
+	assert.Equal(s.T(), errDenied, row.Err())
+	assert.Equal(s.T(), errDenied, row.Scan(new(string)))
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
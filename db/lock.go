@@ -0,0 +1,203 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+)
+
+var (
+	// ErrLockNotAcquired is returned when a lock is held by another session
+	ErrLockNotAcquired = errors.New("db: lock not acquired")
+	// ErrLockUnsupported is returned when the dialect of the database has no advisory locks
+	ErrLockUnsupported = errors.New("db: advisory locks are not supported by the dialect")
+	// ErrNoTransaction is returned when a transaction is required in the context
+	ErrNoTransaction = errors.New("db: no transaction in the context")
+)
+
+// Lock is an advisory lock of the database, held by a dedicated connection
+// until it is released, so that it does not depend on the connections of the pool.
+type Lock struct {
+	name   string
+	key    interface{}
+	unlock string
+
+	mu   sync.Mutex
+	conn *sql.Conn
+}
+
+// Lock takes a session advisory lock, waiting until it is acquired or the context is done.
+// Locks with the same name exclude each other across all the clients of the database.
+func (db *DB) Lock(ctx context.Context, name string) (*Lock, error) {
+	return db.lock(ctx, name, true)
+}
+
+// TryLock takes a session advisory lock if it is free, or fails with ErrLockNotAcquired.
+func (db *DB) TryLock(ctx context.Context, name string) (*Lock, error) {
+	return db.lock(ctx, name, false)
+}
+
+func (db *DB) lock(ctx context.Context, name string, wait bool) (*Lock, error) {
+	lock, unlock := db.Dialect.sessionLock(wait)
+	if lock == "" {
+		return nil, ErrLockUnsupported
+	}
+
+	conn, err := db.DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	key := db.Dialect.lockKey(name)
+	var acquired sql.NullBool
+	if err := conn.QueryRowContext(ctx, lock, key).Scan(&acquired); err != nil {
+		// the lock may have been acquired before the failure
+		discard(conn)
+		return nil, fmt.Errorf("db: can not lock %s: %w", name, err)
+	}
+	if !acquired.Bool {
+		conn.Close()
+		return nil, fmt.Errorf("%w: %s", ErrLockNotAcquired, name)
+	}
+
+	return &Lock{name: name, key: key, unlock: unlock, conn: conn}, nil
+}
+
+// Ping checks the connection holding the lock is alive, the lock is lost with it.
+func (l *Lock) Ping(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn == nil {
+		return fmt.Errorf("db: lock %s is released", l.name)
+	}
+	return l.conn.PingContext(ctx)
+}
+
+// Release releases the lock and its connection, it can be called several times.
+// If the lock can not be released, the connection is closed to release it.
+func (l *Lock) Release(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn == nil {
+		return nil
+	}
+	conn := l.conn
+	l.conn = nil
+
+	if _, err := conn.ExecContext(ctx, l.unlock, l.key); err != nil {
+		discard(conn)
+		return fmt.Errorf("db: can not unlock %s: %w", l.name, err)
+	}
+	return conn.Close()
+}
+
+// LockTx takes an advisory lock released once the transaction of the context has ended,
+// waiting until it is acquired or the context is done.
+func (db *DB) LockTx(ctx context.Context, name string) error {
+	return db.lockTx(ctx, name, true)
+}
+
+// TryLockTx takes an advisory lock released once the transaction of the context has ended
+// if it is free, or fails with ErrLockNotAcquired.
+func (db *DB) TryLockTx(ctx context.Context, name string) error {
+	return db.lockTx(ctx, name, false)
+}
+
+func (db *DB) lockTx(ctx context.Context, name string, wait bool) error {
+	t, ok := txFromContext(ctx)
+	if !ok {
+		return ErrNoTransaction
+	}
+
+	lock := db.Dialect.txLock(wait)
+	if lock == "" {
+		// without transaction locks, a session lock is released by the hooks of the transaction
+		l, err := db.lock(ctx, name, wait)
+		if err != nil {
+			return err
+		}
+		release := func(context.Context) { _ = l.Release(context.Background()) }
+		AfterCommit(ctx, release)
+		AfterRollback(ctx, release)
+		return nil
+	}
+
+	var acquired sql.NullBool
+	if err := t.tx.QueryRowContext(ctx, lock, db.Dialect.lockKey(name)).Scan(&acquired); err != nil {
+		return fmt.Errorf("db: can not lock %s: %w", name, err)
+	}
+	if !acquired.Bool {
+		return fmt.Errorf("%w: %s", ErrLockNotAcquired, name)
+	}
+	return nil
+}
+
+// discard closes a connection and removes it from the pool
+func discard(conn *sql.Conn) {
+	_ = conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	conn.Close()
+}
+
+// lockKey returns the key of a named lock
+func (d Dialect) lockKey(name string) interface{} {
+	if d == Postgres {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(name))
+		return int64(h.Sum64())
+	}
+	return name
+}
+
+// sessionLock returns the statement that takes a session lock, selecting whether it is acquired,
+// and the statement that releases it, or empty strings if the dialect has no advisory locks
+func (d Dialect) sessionLock(wait bool) (lock, unlock string) {
+	switch d {
+	case Postgres:
+		if wait {
+			return "SELECT true FROM pg_advisory_lock($1)", "SELECT pg_advisory_unlock($1)"
+		}
+		return "SELECT pg_try_advisory_lock($1)", "SELECT pg_advisory_unlock($1)"
+	case MySQL:
+		if wait {
+			return "SELECT GET_LOCK(?, -1)", "SELECT RELEASE_LOCK(?)"
+		}
+		return "SELECT GET_LOCK(?, 0)", "SELECT RELEASE_LOCK(?)"
+	case SQLServer:
+		return applock("Session", wait), "EXEC sp_releaseapplock @Resource = @p1, @LockOwner = 'Session'"
+	default:
+		return "", ""
+	}
+}
+
+// txLock returns the statement that takes a transaction lock, selecting whether it is acquired,
+// or an empty string if the dialect has no transaction locks
+func (d Dialect) txLock(wait bool) string {
+	switch d {
+	case Postgres:
+		if wait {
+			return "SELECT true FROM pg_advisory_xact_lock($1)"
+		}
+		return "SELECT pg_try_advisory_xact_lock($1)"
+	case SQLServer:
+		return applock("Transaction", wait)
+	default:
+		return ""
+	}
+}
+
+// applock returns the statement that takes a SQL Server application lock
+func applock(owner string, wait bool) string {
+	timeout := 0
+	if wait {
+		timeout = -1
+	}
+	return fmt.Sprintf("DECLARE @r int; "+
+		"EXEC @r = sp_getapplock @Resource = @p1, @LockMode = 'Exclusive', @LockOwner = '%s', @LockTimeout = %d; "+
+		"SELECT CASE WHEN @r >= 0 THEN 1 ELSE 0 END", owner, timeout)
+}
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestLock will test the session locks.
+func (s *DBTestSuite) TestLock() {
+	ctx := context.Background()
+	s.db.Dialect = Postgres
+
+	// mock
+	key := Postgres.lockKey("leader")
+	s.mock.ExpectQuery("SELECT true FROM pg_advisory_lock($1)").WithArgs(key).
+		WillReturnRows(sqlmock.NewRows([]string{"bool"}).AddRow(true))
+	s.mock.ExpectExec("SELECT pg_advisory_unlock($1)").WithArgs(key).WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectQuery("SELECT pg_try_advisory_lock($1)").WithArgs(key).
+		WillReturnRows(sqlmock.NewRows([]string{"bool"}).AddRow(false))
+
+	// test
+	l, err := s.db.Lock(ctx, "leader")
+	assert.NoError(s.T(), err)
+	assert.NoError(s.T(), l.Release(ctx))
+	assert.NoError(s.T(), l.Release(ctx))
+	assert.Error(s.T(), l.Ping(ctx))
+
+	_, err = s.db.TryLock(ctx, "leader")
+	assert.ErrorIs(s.T(), err, ErrLockNotAcquired)
+
+	s.db.Dialect = SQLite
+	_, err = s.db.Lock(ctx, "leader")
+	assert.ErrorIs(s.T(), err, ErrLockUnsupported)
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
+
+// TestLockTx will test the locks released with the transaction.
+func (s *DBTestSuite) TestLockTx() {
+	ctx := context.Background()
+
+	// mock
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT true FROM pg_advisory_xact_lock($1)").WithArgs(Postgres.lockKey("cron")).
+		WillReturnRows(sqlmock.NewRows([]string{"bool"}).AddRow(true))
+	s.mock.ExpectCommit()
+	// a session lock on its own connection, released after the transaction
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT GET_LOCK(?, 0)").WithArgs("cron").
+		WillReturnRows(sqlmock.NewRows([]string{"GET_LOCK"}).AddRow(1))
+	s.mock.ExpectRollback()
+	s.mock.ExpectExec("SELECT RELEASE_LOCK(?)").WithArgs("cron").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// test
+	s.db.Dialect = Postgres
+	err := s.db.WithTransaction(ctx, func(txCtx context.Context) error {
+		return s.db.LockTx(txCtx, "cron")
+	})
+	assert.NoError(s.T(), err)
+
+	s.db.Dialect = MySQL
+	err = s.db.WithTransaction(ctx, func(txCtx context.Context) error {
+		if err := s.db.TryLockTx(txCtx, "cron"); err != nil {
+			return err
+		}
+		return assert.AnError
+	})
+	assert.ErrorIs(s.T(), err, assert.AnError)
+
+	assert.ErrorIs(s.T(), s.db.LockTx(ctx, "cron"), ErrNoTransaction)
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
@@ -0,0 +1,123 @@
+package db
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryCache is a Cache in the memory of the process
+type MemoryCache struct {
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	tags    map[string]map[string]struct{}
+}
+
+type memoryEntry struct {
+	value   []byte
+	expires time.Time
+	tags    []string
+}
+
+// NewMemoryCache creates a new in-memory cache of at most maxEntries values, 0 for no limit
+func NewMemoryCache(maxEntries int) *MemoryCache {
+	return &MemoryCache{
+		maxEntries: maxEntries,
+		entries:    make(map[string]memoryEntry),
+		tags:       make(map[string]map[string]struct{}),
+	}
+}
+
+// Get implements the Cache interface
+func (c *MemoryCache) Get(_ context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	if time.Now().After(e.expires) {
+		c.remove(key)
+		return nil, false, nil
+	}
+	return e.value, true, nil
+}
+
+// Set implements the Cache interface
+func (c *MemoryCache) Set(_ context.Context, key string, value []byte, ttl time.Duration, tags []string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.remove(key)
+	if c.maxEntries > 0 && len(c.entries) >= c.maxEntries {
+		c.evict()
+	}
+
+	c.entries[key] = memoryEntry{value: value, expires: time.Now().Add(ttl), tags: tags}
+	for _, tag := range tags {
+		keys, ok := c.tags[tag]
+		if !ok {
+			keys = make(map[string]struct{})
+			c.tags[tag] = keys
+		}
+		keys[key] = struct{}{}
+	}
+	return nil
+}
+
+// Invalidate implements the Cache interface
+func (c *MemoryCache) Invalidate(_ context.Context, tags ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, tag := range tags {
+		for key := range c.tags[tag] {
+			c.remove(key)
+		}
+	}
+	return nil
+}
+
+// Len returns the number of cached values, including the expired ones not removed yet
+func (c *MemoryCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// remove removes the value of a key and its tags
+func (c *MemoryCache) remove(key string) {
+	e, ok := c.entries[key]
+	if !ok {
+		return
+	}
+
+	delete(c.entries, key)
+	for _, tag := range e.tags {
+		delete(c.tags[tag], key)
+		if len(c.tags[tag]) == 0 {
+			delete(c.tags, tag)
+		}
+	}
+}
+
+// evict removes the expired values, or a random value if none has expired
+func (c *MemoryCache) evict() {
+	now := time.Now()
+	for key, e := range c.entries {
+		if now.After(e.expires) {
+			c.remove(key)
+		}
+	}
+	if len(c.entries) < c.maxEntries {
+		return
+	}
+
+	for key := range c.entries {
+		c.remove(key)
+		return
+	}
+}
@@ -0,0 +1,118 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/instrument"
+	"go.opentelemetry.io/otel/metric/instrument/asyncint64"
+	"go.opentelemetry.io/otel/metric/unit"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+)
+
+const meterName = "github.com/org39/gopkg/db"
+
+// dbPoolKey is the connection pool of the statistics, the primary or a replica
+var dbPoolKey = attribute.Key("db.pool")
+
+// WithName is a database option that sets the name of the database,
+// which labels its spans and metrics
+func WithName(name string) func(*DB) error {
+	return func(db *DB) error {
+		db.Name = name
+		return nil
+	}
+}
+
+// WithMeterProvider is a database option that exports the connection pool statistics
+// through the given OpenTelemetry meter provider
+func WithMeterProvider(mp metric.MeterProvider) func(*DB) error {
+	return func(db *DB) error {
+		db.MeterProvider = mp
+		return nil
+	}
+}
+
+// poolMetrics are the instruments of the connection pool statistics
+type poolMetrics struct {
+	maxOpen asyncint64.Gauge
+	open    asyncint64.Gauge
+	inUse   asyncint64.Gauge
+	idle    asyncint64.Gauge
+
+	waitCount         asyncint64.Counter
+	waitDuration      asyncint64.Counter
+	maxIdleClosed     asyncint64.Counter
+	maxIdleTimeClosed asyncint64.Counter
+	maxLifetimeClosed asyncint64.Counter
+}
+
+// registerMetrics registers the observers of the connection pool statistics
+// of the primary database and of its replicas
+func (db *DB) registerMetrics() error {
+	meter := db.MeterProvider.Meter(meterName)
+	gauges := meter.AsyncInt64()
+
+	var m poolMetrics
+	var err error
+	gauge := func(name, desc string) asyncint64.Gauge {
+		if err != nil {
+			return nil
+		}
+		var g asyncint64.Gauge
+		g, err = gauges.Gauge(name, instrument.WithDescription(desc), instrument.WithUnit(unit.Dimensionless))
+		return g
+	}
+	counter := func(name, desc string, u unit.Unit) asyncint64.Counter {
+		if err != nil {
+			return nil
+		}
+		var c asyncint64.Counter
+		c, err = gauges.Counter(name, instrument.WithDescription(desc), instrument.WithUnit(u))
+		return c
+	}
+
+	m.maxOpen = gauge("db.sql.connections.max_open", "Maximum number of open connections to the database")
+	m.open = gauge("db.sql.connections.open", "Number of established connections both in use and idle")
+	m.inUse = gauge("db.sql.connections.in_use", "Number of connections currently in use")
+	m.idle = gauge("db.sql.connections.idle", "Number of idle connections")
+	m.waitCount = counter("db.sql.connections.wait_count", "Total number of connections waited for", unit.Dimensionless)
+	m.waitDuration = counter("db.sql.connections.wait_duration", "Total time blocked waiting for a new connection", unit.Milliseconds)
+	m.maxIdleClosed = counter("db.sql.connections.max_idle_closed", "Total number of connections closed due to SetMaxIdleConns", unit.Dimensionless)
+	m.maxIdleTimeClosed = counter("db.sql.connections.max_idle_time_closed", "Total number of connections closed due to SetConnMaxIdleTime", unit.Dimensionless)
+	m.maxLifetimeClosed = counter("db.sql.connections.max_lifetime_closed", "Total number of connections closed due to SetConnMaxLifetime", unit.Dimensionless)
+	if err != nil {
+		return fmt.Errorf("db: can not create metrics: %w", err)
+	}
+
+	pools := map[string]*sql.DB{"primary": db.DB}
+	if db.replicas != nil {
+		for i, r := range db.replicas.replicas {
+			pools[fmt.Sprintf("replica-%d", i)] = r.db
+		}
+	}
+
+	insts := []instrument.Asynchronous{
+		m.maxOpen, m.open, m.inUse, m.idle,
+		m.waitCount, m.waitDuration, m.maxIdleClosed, m.maxIdleTimeClosed, m.maxLifetimeClosed,
+	}
+	return meter.RegisterCallback(insts, func(ctx context.Context) {
+		for pool, pdb := range pools {
+			attrs := []attribute.KeyValue{semconv.DBNameKey.String(db.Name), dbPoolKey.String(pool)}
+			stats := pdb.Stats()
+
+			m.maxOpen.Observe(ctx, int64(stats.MaxOpenConnections), attrs...)
+			m.open.Observe(ctx, int64(stats.OpenConnections), attrs...)
+			m.inUse.Observe(ctx, int64(stats.InUse), attrs...)
+			m.idle.Observe(ctx, int64(stats.Idle), attrs...)
+			m.waitCount.Observe(ctx, stats.WaitCount, attrs...)
+			m.waitDuration.Observe(ctx, stats.WaitDuration.Milliseconds(), attrs...)
+			m.maxIdleClosed.Observe(ctx, stats.MaxIdleClosed, attrs...)
+			m.maxIdleTimeClosed.Observe(ctx, stats.MaxIdleTimeClosed, attrs...)
+			m.maxLifetimeClosed.Observe(ctx, stats.MaxLifetimeClosed, attrs...)
+		}
+	})
+}
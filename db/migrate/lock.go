@@ -0,0 +1,23 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+
+	"github.com/org39/gopkg/db"
+)
+
+// lock takes an advisory lock named after the migration table, so that concurrent
+// migrators wait for each other. The returned function releases the lock.
+func (m *Migrator) lock(ctx context.Context) (func(), error) {
+	l, err := m.db.Lock(ctx, m.table)
+	if errors.Is(err, db.ErrLockUnsupported) {
+		// the database serializes the writers by itself
+		return func() {}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return func() { _ = l.Release(context.Background()) }, nil
+}
@@ -0,0 +1,339 @@
+// Package migrate applies versioned schema migrations to a database.
+//
+// Migrations are read from the root of a fs.FS, like an embed.FS or os.DirFS,
+// as pairs of files named <version>_<name>.up.sql and <version>_<name>.down.sql.
+// A file may hold several statements if the driver allows it, like MySQL with multiStatements=true.
+// The SQL of a file is executed as is with db.DB.ExecRaw: ? and :name are not parameters.
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/org39/gopkg/db"
+)
+
+var (
+	// ErrChecksumMismatch is returned when an applied migration has been modified since
+	ErrChecksumMismatch = errors.New("migrate: applied migration has been modified")
+	// ErrIrreversible is returned when a migration to revert has no down migration
+	ErrIrreversible = errors.New("migrate: migration has no down migration")
+	// ErrUnknownVersion is returned when the target version has no migration
+	ErrUnknownVersion = errors.New("migrate: unknown version")
+)
+
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+// Migrator applies the migrations of a source to a database
+type Migrator struct {
+	db         *db.DB
+	migrations []Migration
+
+	// table is the schema table recording the applied migrations
+	table string
+}
+
+// Status is the state of a migration in the database
+type Status struct {
+	Migration
+
+	// Applied is true if the migration is applied.
+	Applied bool
+	// AppliedAt is the time the migration was applied.
+	AppliedAt time.Time
+	// Modified is true if the migration has been modified since it was applied.
+	Modified bool
+	// Missing is true if the migration is applied but not found in the source.
+	Missing bool
+}
+
+// applied is a row of the schema table
+type applied struct {
+	Version   int64     `db:"version"`
+	Name      string    `db:"name"`
+	Checksum  string    `db:"checksum"`
+	AppliedAt time.Time `db:"applied_at"`
+}
+
+// New creates a new migrator of the migrations read from the source
+func New(d *db.DB, source fs.FS, options ...func(*Migrator) error) (*Migrator, error) {
+	m := &Migrator{
+		db:    d,
+		table: "schema_migrations",
+	}
+
+	for _, option := range options {
+		err := option(m)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	migrations, err := load(source)
+	if err != nil {
+		return nil, err
+	}
+	m.migrations = migrations
+
+	return m, nil
+}
+
+// WithTable is a migrator option that sets the name of the schema table
+func WithTable(table string) func(*Migrator) error {
+	return func(m *Migrator) error {
+		if !identifierPattern.MatchString(table) {
+			return fmt.Errorf("migrate: invalid table name %q", table)
+		}
+		m.table = table
+		return nil
+	}
+}
+
+// Up applies all pending migrations
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.locked(ctx, func(applied map[int64]applied) error {
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			if err := m.apply(ctx, migration); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Down reverts the last applied migration
+func (m *Migrator) Down(ctx context.Context) error {
+	return m.locked(ctx, func(applied map[int64]applied) error {
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			if _, ok := applied[m.migrations[i].Version]; ok {
+				return m.revert(ctx, m.migrations[i])
+			}
+		}
+		return nil
+	})
+}
+
+// To applies or reverts migrations until the given version is the last applied one.
+// Version 0 reverts all migrations.
+func (m *Migrator) To(ctx context.Context, version int64) error {
+	if version != 0 && m.find(version) < 0 {
+		return fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+
+	return m.locked(ctx, func(applied map[int64]applied) error {
+		// revert the migrations after the version, latest first
+		for i := len(m.migrations) - 1; i >= 0 && m.migrations[i].Version > version; i-- {
+			if _, ok := applied[m.migrations[i].Version]; ok {
+				if err := m.revert(ctx, m.migrations[i]); err != nil {
+					return err
+				}
+			}
+		}
+
+		// apply the migrations until the version
+		for _, migration := range m.migrations {
+			if migration.Version > version {
+				break
+			}
+			if _, ok := applied[migration.Version]; !ok {
+				if err := m.apply(ctx, migration); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Status returns the status of the migrations of the source,
+// and of the applied migrations not found in the source, ordered by version
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	// nothing is applied until the schema table is created by a migration
+	exists, err := m.tableExists(ctx)
+	if err != nil {
+		return nil, err
+	}
+	applied := map[int64]applied{}
+	if exists {
+		if applied, err = m.applied(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		s := Status{Migration: migration}
+		if a, ok := applied[migration.Version]; ok {
+			s.Applied, s.AppliedAt = true, a.AppliedAt
+			s.Modified = a.Checksum != migration.Checksum
+			delete(applied, migration.Version)
+		}
+		statuses = append(statuses, s)
+	}
+	for _, a := range applied {
+		statuses = append(statuses, Status{
+			Migration: Migration{Version: a.Version, Name: a.Name, Checksum: a.Checksum},
+			Applied:   true,
+			AppliedAt: a.AppliedAt,
+			Missing:   true,
+		})
+	}
+	sortStatuses(statuses)
+
+	return statuses, nil
+}
+
+// locked executes a function with the applied migrations, holding the migration lock
+func (m *Migrator) locked(ctx context.Context, fn func(map[int64]applied) error) error {
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err = m.createTable(ctx); err != nil {
+		return err
+	}
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return err
+	}
+
+	// refuse to migrate on top of modified migrations
+	for _, migration := range m.migrations {
+		if a, ok := applied[migration.Version]; ok && a.Checksum != migration.Checksum {
+			return fmt.Errorf("%w: %d_%s", ErrChecksumMismatch, migration.Version, migration.Name)
+		}
+	}
+
+	return fn(applied)
+}
+
+// createTable creates the schema table if it does not exist
+func (m *Migrator) createTable(ctx context.Context) error {
+	var query string
+	switch m.db.Dialect {
+	case db.SQLServer:
+		query = fmt.Sprintf(`IF OBJECT_ID(N'%[1]s', N'U') IS NULL CREATE TABLE %[1]s (
+	version BIGINT NOT NULL PRIMARY KEY,
+	name NVARCHAR(255) NOT NULL,
+	checksum CHAR(64) NOT NULL,
+	applied_at DATETIME2 NOT NULL
+)`, m.table)
+	default:
+		query = fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	version BIGINT NOT NULL PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	checksum CHAR(64) NOT NULL,
+	applied_at TIMESTAMP NOT NULL
+)`, m.table)
+	}
+
+	_, err := m.db.ExecRaw(ctx, query)
+	return err
+}
+
+// tableExists reports whether the schema table exists
+func (m *Migrator) tableExists(ctx context.Context) (bool, error) {
+	schema, table := "", m.table
+	if i := strings.LastIndexByte(m.table, '.'); i >= 0 {
+		schema, table = m.table[:i], m.table[i+1:]
+	}
+
+	var query string
+	args := []interface{}{m.table}
+	switch m.db.Dialect {
+	case db.Postgres:
+		query = "SELECT to_regclass(?) IS NOT NULL"
+	case db.SQLServer:
+		query = "SELECT CASE WHEN OBJECT_ID(?, N'U') IS NULL THEN 0 ELSE 1 END"
+	case db.SQLite:
+		query = "SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = ?"
+		args = []interface{}{table}
+	default:
+		query = "SELECT COUNT(*) > 0 FROM information_schema.tables WHERE table_schema = COALESCE(NULLIF(?, ''), DATABASE()) AND table_name = ?"
+		args = []interface{}{schema, table}
+	}
+
+	var exists bool
+	err := m.db.QueryRow(db.UseRebind(db.UsePrimary(ctx)), query, args...).Scan(&exists)
+	return exists, err
+}
+
+// applied returns the applied migrations by version
+func (m *Migrator) applied(ctx context.Context) (map[int64]applied, error) {
+	query := fmt.Sprintf("SELECT version, name, checksum, applied_at FROM %s ORDER BY version", m.table)
+	rows, err := db.Select[applied](db.UsePrimary(ctx), m.db, query)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]applied, len(rows))
+	for _, row := range rows {
+		byVersion[row.Version] = row
+	}
+	return byVersion, nil
+}
+
+// apply applies a migration and records it,
+// within a transaction if the dialect supports transactional DDL
+func (m *Migrator) apply(ctx context.Context, migration Migration) error {
+	insert := fmt.Sprintf("INSERT INTO %s (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)", m.table)
+
+	err := m.run(ctx, migration.Up, insert,
+		migration.Version, migration.Name, migration.Checksum, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("migrate: can not apply %d_%s: %w", migration.Version, migration.Name, err)
+	}
+	return nil
+}
+
+// revert reverts a migration and forgets it,
+// within a transaction if the dialect supports transactional DDL
+func (m *Migrator) revert(ctx context.Context, migration Migration) error {
+	if migration.Down == "" {
+		return fmt.Errorf("%w: %d_%s", ErrIrreversible, migration.Version, migration.Name)
+	}
+
+	remove := fmt.Sprintf("DELETE FROM %s WHERE version = ?", m.table)
+	if err := m.run(ctx, migration.Down, remove, migration.Version); err != nil {
+		return fmt.Errorf("migrate: can not revert %d_%s: %w", migration.Version, migration.Name, err)
+	}
+	return nil
+}
+
+// run executes the SQL of a migration, then the statement recording it
+func (m *Migrator) run(ctx context.Context, migration string, record string, args ...interface{}) error {
+	fn := func(ctx context.Context) error {
+		// the SQL of the migration is not bound, ? and :name are left as is
+		if _, err := m.db.ExecRaw(ctx, migration); err != nil {
+			return err
+		}
+		_, err := m.db.Exec(db.UseRebind(ctx), record, args...)
+		return err
+	}
+
+	// DDL statements commit the current transaction implicitly in MySQL
+	if m.db.Dialect == db.MySQL {
+		return fn(ctx)
+	}
+	return m.db.WithTransaction(ctx, fn)
+}
+
+// find returns the index of the migration of the version, or -1
+func (m *Migrator) find(version int64) int {
+	for i, migration := range m.migrations {
+		if migration.Version == version {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,205 @@
+package migrate
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/org39/gopkg/db"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+var source = fstest.MapFS{
+	"0001_create_users.up.sql":   {Data: []byte("CREATE TABLE users (id BIGINT PRIMARY KEY)")},
+	"0001_create_users.down.sql": {Data: []byte("DROP TABLE users")},
+	"0002_create_posts.up.sql":   {Data: []byte("CREATE TABLE posts (id BIGINT PRIMARY KEY)")},
+	"0002_create_posts.down.sql": {Data: []byte("DROP TABLE posts")},
+	"README.md":                  {Data: []byte("not a migration")},
+}
+
+type MigrateTestSuite struct {
+	suite.Suite
+	migrator *Migrator
+	mock     sqlmock.Sqlmock
+}
+
+// SetupTest will be run before every test in the suite.
+func (s *MigrateTestSuite) SetupTest() {
+	mockdb, mock, err := sqlmock.New()
+	if err != nil {
+		panic(err)
+	}
+
+	mock.MatchExpectationsInOrder(true)
+	s.migrator, err = New(&db.DB{DB: mockdb, Dialect: db.Postgres}, source)
+	if err != nil {
+		panic(err)
+	}
+	s.mock = mock
+}
+
+func (s *MigrateTestSuite) expectLocked(applied ...Migration) {
+	s.mock.ExpectQuery(`SELECT true FROM pg_advisory_lock\(\$1\)`).WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"bool"}).AddRow(true))
+	s.mock.ExpectExec(`CREATE TABLE IF NOT EXISTS schema_migrations`).WillReturnResult(sqlmock.NewResult(0, 0))
+	s.expectApplied(applied...)
+}
+
+func (s *MigrateTestSuite) expectTableExists(exists bool) {
+	s.mock.ExpectQuery(`SELECT to_regclass\(\$1\) IS NOT NULL`).WithArgs("schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(exists))
+}
+
+func (s *MigrateTestSuite) expectApplied(applied ...Migration) {
+	rows := sqlmock.NewRows([]string{"version", "name", "checksum", "applied_at"})
+	for _, a := range applied {
+		rows.AddRow(a.Version, a.Name, a.Checksum, time.Now())
+	}
+	s.mock.ExpectQuery(`SELECT version, name, checksum, applied_at FROM schema_migrations`).WillReturnRows(rows)
+}
+
+func (s *MigrateTestSuite) expectUnlock() {
+	s.mock.ExpectExec(`SELECT pg_advisory_unlock\(\$1\)`).WithArgs(sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+// TestUp will test the pending migrations applied within transactions.
+func (s *MigrateTestSuite) TestUp() {
+	ctx := context.Background()
+	first, second := s.migrator.migrations[0], s.migrator.migrations[1]
+
+	// mock
+	s.expectLocked(first)
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(`CREATE TABLE posts`).WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectExec(`INSERT INTO schema_migrations \(version, name, checksum, applied_at\) VALUES \(\$1, \$2, \$3, \$4\)`).
+		WithArgs(second.Version, second.Name, second.Checksum, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+	s.expectUnlock()
+
+	// test
+	assert.NoError(s.T(), s.migrator.Up(ctx))
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
+
+// TestTo will test the migrations after the target version reverted.
+func (s *MigrateTestSuite) TestTo() {
+	ctx := context.Background()
+	first, second := s.migrator.migrations[0], s.migrator.migrations[1]
+
+	// mock
+	s.expectLocked(first, second)
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(`DROP TABLE posts`).WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectExec(`DELETE FROM schema_migrations WHERE version = \$1`).
+		WithArgs(second.Version).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+	s.expectUnlock()
+
+	// test
+	assert.NoError(s.T(), s.migrator.To(ctx, 1))
+	assert.ErrorIs(s.T(), s.migrator.To(ctx, 3), ErrUnknownVersion)
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
+
+// TestChecksumMismatch will test the migration refused on top of a modified migration.
+func (s *MigrateTestSuite) TestChecksumMismatch() {
+	ctx := context.Background()
+	modified := s.migrator.migrations[0]
+	modified.Checksum = checksum("CREATE TABLE users (id INT PRIMARY KEY)")
+
+	// mock
+	s.expectLocked(modified)
+	s.expectUnlock()
+
+	// test
+	assert.ErrorIs(s.T(), s.migrator.Down(ctx), ErrChecksumMismatch)
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
+
+// TestStatus will test the status of applied, modified, pending and missing migrations.
+func (s *MigrateTestSuite) TestStatus() {
+	ctx := context.Background()
+	modified := s.migrator.migrations[0]
+	modified.Checksum = checksum("CREATE TABLE users (id INT PRIMARY KEY)")
+	missing := Migration{Version: 3, Name: "create_tags", Checksum: checksum("CREATE TABLE tags")}
+
+	// mock
+	s.expectTableExists(true)
+	s.expectApplied(modified, missing)
+
+	// test
+	statuses, err := s.migrator.Status(ctx)
+	assert.NoError(s.T(), err)
+	if assert.Len(s.T(), statuses, 3) {
+		assert.True(s.T(), statuses[0].Applied)
+		assert.True(s.T(), statuses[0].Modified)
+		assert.False(s.T(), statuses[1].Applied)
+		assert.True(s.T(), statuses[2].Missing)
+	}
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
+
+// TestStatusWithoutTable will test the status of a database without schema table, which is not created.
+func (s *MigrateTestSuite) TestStatusWithoutTable() {
+	ctx := context.Background()
+
+	// mock
+	s.expectTableExists(false)
+
+	// test
+	statuses, err := s.migrator.Status(ctx)
+	assert.NoError(s.T(), err)
+	if assert.Len(s.T(), statuses, 2) {
+		assert.False(s.T(), statuses[0].Applied)
+		assert.False(s.T(), statuses[1].Applied)
+	}
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
+
+// TestUpRaw will test the SQL of a migration executed as is, without binding its ? and :name.
+func (s *MigrateTestSuite) TestUpRaw() {
+	ctx := context.Background()
+	up := "CREATE INDEX users_admin ON users (id) WHERE data ? 'admin' AND note <> ':name'"
+	s.migrator.db.Rebind = true
+	migrator, err := New(s.migrator.db, fstest.MapFS{"0001_index_admins.up.sql": {Data: []byte(up)}})
+	assert.NoError(s.T(), err)
+	migration := migrator.migrations[0]
+
+	// mock
+	s.expectLocked()
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta(up)).WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectExec(`INSERT INTO schema_migrations \(version, name, checksum, applied_at\) VALUES \(\$1, \$2, \$3, \$4\)`).
+		WithArgs(migration.Version, migration.Name, migration.Checksum, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+	s.expectUnlock()
+
+	// test
+	assert.NoError(s.T(), migrator.Up(ctx))
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
+
+func TestMigrateTestSuite(t *testing.T) {
+	suite.Run(t, new(MigrateTestSuite))
+}
+
+func TestLoad(t *testing.T) {
+	migrations, err := load(source)
+	assert.NoError(t, err)
+	if assert.Len(t, migrations, 2) {
+		assert.Equal(t, int64(1), migrations[0].Version)
+		assert.Equal(t, "create_users", migrations[0].Name)
+		assert.Equal(t, "DROP TABLE users", migrations[0].Down)
+	}
+
+	_, err = load(fstest.MapFS{"0001_create_users.down.sql": {Data: []byte("DROP TABLE users")}})
+	assert.Error(t, err)
+}
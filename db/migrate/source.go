@@ -0,0 +1,93 @@
+package migrate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// filePattern matches the migration files, like 0001_create_users.up.sql
+var filePattern = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Migration is a versioned change of the schema
+type Migration struct {
+	// Version orders the migrations, it is the numeric prefix of the file name.
+	Version int64
+	// Name is the name of the migration, between the version and the direction.
+	Name string
+	// Up is the SQL applying the migration.
+	Up string
+	// Down is the SQL reverting the migration, empty if it can not be reverted.
+	Down string
+	// Checksum is the SHA-256 of the Up SQL.
+	Checksum string
+}
+
+// load reads the migrations at the root of the source, ordered by version
+func load(source fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(source, ".")
+	if err != nil {
+		return nil, fmt.Errorf("migrate: can not read migrations: %w", err)
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		m := filePattern.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || m == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migrate: invalid version of %s: %w", entry.Name(), err)
+		}
+		body, err := fs.ReadFile(source, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("migrate: can not read %s: %w", entry.Name(), err)
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != m[2] {
+			return nil, fmt.Errorf("migrate: version %d is used by %s and %s", version, migration.Name, m[2])
+		}
+
+		if m[3] == "up" {
+			migration.Up = string(body)
+		} else {
+			migration.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migrate: version %d has no up migration", migration.Version)
+		}
+		migration.Checksum = checksum(migration.Up)
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+func checksum(sql string) string {
+	sum := sha256.Sum256([]byte(sql))
+	return hex.EncodeToString(sum[:])
+}
+
+func sortStatuses(statuses []Status) {
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+}
@@ -0,0 +1,170 @@
+package db
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// Null is a nullable T, mapped to SQL NULL and JSON null when it is not valid.
+// T is a primitive type, a time.Time, or a type implementing sql.Scanner and driver.Valuer.
+type Null[T any] struct {
+	V     T
+	Valid bool
+}
+
+// NewNull returns a valid Null of a value
+func NewNull[T any](v T) Null[T] {
+	return Null[T]{V: v, Valid: true}
+}
+
+// Ptr returns a pointer to the value, nil if it is not valid
+func (n Null[T]) Ptr() *T {
+	if !n.Valid {
+		return nil
+	}
+	v := n.V
+	return &v
+}
+
+// Scan implements the sql.Scanner interface
+func (n *Null[T]) Scan(value interface{}) error {
+	var zero T
+	n.V, n.Valid = zero, false
+	if value == nil {
+		return nil
+	}
+
+	if s, ok := interface{}(&n.V).(sql.Scanner); ok {
+		if err := s.Scan(value); err != nil {
+			return err
+		}
+	} else if err := assign(reflect.ValueOf(&n.V).Elem(), value); err != nil {
+		return err
+	}
+
+	n.Valid = true
+	return nil
+}
+
+// Value implements the driver.Valuer interface
+func (n Null[T]) Value() (driver.Value, error) {
+	if !n.Valid {
+		return nil, nil
+	}
+	if v, ok := interface{}(n.V).(driver.Valuer); ok {
+		return v.Value()
+	}
+	return driver.DefaultParameterConverter.ConvertValue(n.V)
+}
+
+// MarshalJSON implements the json.Marshaler interface
+func (n Null[T]) MarshalJSON() ([]byte, error) {
+	if !n.Valid {
+		return []byte("null"), nil
+	}
+	return json.Marshal(n.V)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface
+func (n *Null[T]) UnmarshalJSON(data []byte) error {
+	var zero T
+	n.V, n.Valid = zero, false
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		return nil
+	}
+
+	if err := json.Unmarshal(data, &n.V); err != nil {
+		return err
+	}
+	n.Valid = true
+	return nil
+}
+
+// assign assigns a value returned by a driver to a destination,
+// converting numbers, booleans and strings between each other
+func assign(dest reflect.Value, src interface{}) error {
+	switch s := src.(type) {
+	case string:
+		return assignString(dest, src, s)
+	case []byte:
+		if dest.Kind() == reflect.Slice && dest.Type().Elem().Kind() == reflect.Uint8 {
+			dest.SetBytes(append([]byte(nil), s...))
+			return nil
+		}
+		return assignString(dest, src, string(s))
+	}
+
+	sv := reflect.ValueOf(src)
+	if sv.Type().AssignableTo(dest.Type()) {
+		dest.Set(sv)
+		return nil
+	}
+
+	switch sv.Kind() {
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		// numbers and booleans are converted through their text
+		return assignString(dest, src, fmt.Sprint(src))
+	}
+	return fmt.Errorf("db: can not scan %T into %s", src, dest.Type())
+}
+
+// assignString parses the text of a value into a destination
+func assignString(dest reflect.Value, src interface{}, s string) error {
+	var err error
+	switch dest.Kind() {
+	case reflect.String:
+		dest.SetString(s)
+	case reflect.Bool:
+		var b bool
+		if b, err = strconv.ParseBool(s); err == nil {
+			dest.SetBool(b)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		if i, err = strconv.ParseInt(s, 10, dest.Type().Bits()); err == nil {
+			dest.SetInt(i)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var u uint64
+		if u, err = strconv.ParseUint(s, 10, dest.Type().Bits()); err == nil {
+			dest.SetUint(u)
+		}
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		if f, err = strconv.ParseFloat(s, dest.Type().Bits()); err == nil {
+			dest.SetFloat(f)
+		}
+	case reflect.Slice:
+		if dest.Type().Elem().Kind() != reflect.Uint8 {
+			return fmt.Errorf("db: can not scan %T into %s", src, dest.Type())
+		}
+		dest.SetBytes([]byte(s))
+	case reflect.Struct:
+		if dest.Type() != timeType {
+			return fmt.Errorf("db: can not scan %T into %s", src, dest.Type())
+		}
+		var t time.Time
+		if t, err = time.Parse(time.RFC3339Nano, s); err != nil {
+			t, err = time.Parse("2006-01-02 15:04:05.999999999", s)
+		}
+		if err == nil {
+			dest.Set(reflect.ValueOf(t))
+		}
+	default:
+		return fmt.Errorf("db: can not scan %T into %s", src, dest.Type())
+	}
+
+	if err != nil {
+		return fmt.Errorf("db: can not scan %T into %s: %w", src, dest.Type(), err)
+	}
+	return nil
+}
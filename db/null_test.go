@@ -0,0 +1,86 @@
+package db
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNullScan(t *testing.T) {
+	now := time.Date(2022, 5, 1, 12, 30, 0, 0, time.UTC)
+
+	var s Null[string]
+	assert.NoError(t, s.Scan([]byte("name")))
+	assert.Equal(t, NewNull("name"), s)
+	assert.NoError(t, s.Scan(nil))
+	assert.Equal(t, Null[string]{}, s)
+
+	var i Null[int32]
+	assert.NoError(t, i.Scan(int64(42)))
+	assert.Equal(t, NewNull(int32(42)), i)
+	assert.NoError(t, i.Scan([]byte("7")))
+	assert.Equal(t, NewNull(int32(7)), i)
+	assert.Error(t, i.Scan(int64(1)<<40))
+	assert.False(t, i.Valid)
+
+	var b Null[bool]
+	assert.NoError(t, b.Scan(int64(1)))
+	assert.Equal(t, NewNull(true), b)
+
+	var ts Null[time.Time]
+	assert.NoError(t, ts.Scan(now))
+	assert.Equal(t, NewNull(now), ts)
+	assert.NoError(t, ts.Scan("2022-05-01 12:30:00"))
+	assert.Equal(t, NewNull(now), ts)
+
+	// a T implementing sql.Scanner scans itself
+	var ns Null[sql.NullInt64]
+	assert.NoError(t, ns.Scan(int64(3)))
+	assert.Equal(t, NewNull(sql.NullInt64{Int64: 3, Valid: true}), ns)
+}
+
+func TestNullValue(t *testing.T) {
+	type status string
+
+	tests := []struct {
+		name  string
+		value driver.Valuer
+		want  driver.Value
+	}{
+		{name: "null", value: Null[string]{}, want: nil},
+		{name: "string", value: NewNull("name"), want: "name"},
+		{name: "named", value: NewNull(status("active")), want: "active"},
+		{name: "int", value: NewNull(int32(42)), want: int64(42)},
+		{name: "valuer", value: NewNull(sql.NullInt64{Int64: 3, Valid: true}), want: int64(3)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.value.Value()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestNullJSON(t *testing.T) {
+	type dto struct {
+		Name  Null[string] `json:"name"`
+		Age   Null[int]    `json:"age"`
+		Email Null[string] `json:"email"`
+	}
+
+	data, err := json.Marshal(dto{Name: NewNull("name"), Age: NewNull(0)})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"name":"name","age":0,"email":null}`, string(data))
+
+	var got dto
+	assert.NoError(t, json.Unmarshal([]byte(`{"name":"name","age":0,"email":null}`), &got))
+	assert.Equal(t, dto{Name: NewNull("name"), Age: NewNull(0)}, got)
+	assert.Equal(t, "name", *got.Name.Ptr())
+	assert.Nil(t, got.Email.Ptr())
+}
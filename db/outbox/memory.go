@@ -0,0 +1,46 @@
+package outbox
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryPublisher is a publisher keeping the published messages in memory, for tests
+type MemoryPublisher struct {
+	mu       sync.Mutex
+	messages []Message
+	fail     func(Message) error
+}
+
+// NewMemoryPublisher creates a new in-memory publisher
+func NewMemoryPublisher() *MemoryPublisher {
+	return &MemoryPublisher{}
+}
+
+// FailWith makes the publisher fail the messages for which fn returns an error
+func (p *MemoryPublisher) FailWith(fn func(Message) error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fail = fn
+}
+
+// Publish implements Publisher
+func (p *MemoryPublisher) Publish(ctx context.Context, msg Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.fail != nil {
+		if err := p.fail(msg); err != nil {
+			return err
+		}
+	}
+	p.messages = append(p.messages, msg)
+	return nil
+}
+
+// Messages returns the published messages, in publishing order
+func (p *MemoryPublisher) Messages() []Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Message(nil), p.messages...)
+}
@@ -0,0 +1,166 @@
+// Package outbox implements the transactional outbox pattern on top of db.DB.
+//
+// Messages are enqueued in the transaction of the caller, so that they are published
+// if and only if the transaction commits. A relay polls the pending messages and hands
+// them to a publisher, retrying the failed ones until they are dead-lettered.
+// The polled messages are leased by pushing back their next attempt, so that they are
+// published without holding the locks of a transaction, and at least once.
+//
+// The outbox table is expected to look like this, in the dialect of the database:
+//
+//	CREATE TABLE outbox (
+//		id BIGSERIAL PRIMARY KEY,
+//		topic VARCHAR(255) NOT NULL,
+//		payload BYTEA NOT NULL,
+//		attempts INT NOT NULL DEFAULT 0,
+//		last_error TEXT NULL,
+//		created_at TIMESTAMP NOT NULL,
+//		next_attempt_at TIMESTAMP NOT NULL,
+//		delivered_at TIMESTAMP NULL,
+//		dead_at TIMESTAMP NULL
+//	);
+//	CREATE INDEX outbox_pending ON outbox (next_attempt_at) WHERE delivered_at IS NULL AND dead_at IS NULL;
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/org39/gopkg/db"
+)
+
+// ErrNoTransaction is returned when a message is enqueued outside a transaction
+var ErrNoTransaction = errors.New("outbox: message must be enqueued within a transaction")
+
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+// Message is a message of the outbox
+type Message struct {
+	ID        int64     `db:"id"`
+	Topic     string    `db:"topic"`
+	Payload   []byte    `db:"payload"`
+	Attempts  int       `db:"attempts"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// Outbox is a table of messages to publish
+type Outbox struct {
+	db *db.DB
+
+	// table is the name of the outbox table
+	table string
+}
+
+// New creates a new outbox on the database
+func New(d *db.DB, options ...func(*Outbox) error) (*Outbox, error) {
+	o := &Outbox{
+		db:    d,
+		table: "outbox",
+	}
+
+	for _, option := range options {
+		err := option(o)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return o, nil
+}
+
+// WithTable is an outbox option that sets the name of the outbox table
+func WithTable(table string) func(*Outbox) error {
+	return func(o *Outbox) error {
+		if !identifierPattern.MatchString(table) {
+			return fmt.Errorf("outbox: invalid table name %q", table)
+		}
+		o.table = table
+		return nil
+	}
+}
+
+// Enqueue writes a message in the outbox, within the transaction of the context.
+// The message is published by the relay once the transaction has committed.
+func (o *Outbox) Enqueue(ctx context.Context, topic string, payload []byte) error {
+	if !db.InTransaction(ctx) {
+		return ErrNoTransaction
+	}
+
+	now := time.Now().UTC()
+	query := fmt.Sprintf("INSERT INTO %s (topic, payload, attempts, created_at, next_attempt_at) VALUES (?, ?, 0, ?, ?)", o.table)
+	_, err := o.db.Exec(db.UseRebind(ctx), query, topic, payload, now, now)
+	return err
+}
+
+// pendingQuery returns the query locking a batch of pending messages,
+// skipping the messages locked by the other relays
+func (o *Outbox) pendingQuery() string {
+	columns := "id, topic, payload, attempts, created_at"
+	where := "delivered_at IS NULL AND dead_at IS NULL AND next_attempt_at <= ?"
+
+	switch o.db.Dialect {
+	case db.SQLServer:
+		return fmt.Sprintf("SELECT TOP (?) %s FROM %s WITH (UPDLOCK, READPAST, ROWLOCK) WHERE %s ORDER BY id", columns, o.table, where)
+	case db.SQLite:
+		// the database serializes the writers by itself
+		return fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY id LIMIT ?", columns, o.table, where)
+	default:
+		return fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY id LIMIT ? FOR UPDATE SKIP LOCKED", columns, o.table, where)
+	}
+}
+
+// claim leases a batch of pending messages until the given time, within a transaction of its own,
+// so that they are skipped by the relays while they are published
+func (o *Outbox) claim(ctx context.Context, now, until time.Time, limit int) ([]Message, error) {
+	var messages []Message
+	err := o.db.WithTransaction(ctx, func(txCtx context.Context) error {
+		var err error
+		if messages, err = o.pending(txCtx, now, limit); err != nil || len(messages) == 0 {
+			return err
+		}
+
+		ids := make([]int64, len(messages))
+		for i, msg := range messages {
+			ids[i] = msg.ID
+		}
+		query := fmt.Sprintf("UPDATE %s SET next_attempt_at = ? WHERE id IN (?)", o.table)
+		_, err = o.db.Exec(db.UseRebind(txCtx), query, until, ids)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// pending locks a batch of pending messages within the transaction of the context
+func (o *Outbox) pending(ctx context.Context, now time.Time, limit int) ([]Message, error) {
+	if o.db.Dialect == db.SQLServer {
+		return db.Select[Message](db.UseRebind(ctx), o.db, o.pendingQuery(), limit, now)
+	}
+	return db.Select[Message](db.UseRebind(ctx), o.db, o.pendingQuery(), now, limit)
+}
+
+// delivered marks a message as delivered
+func (o *Outbox) delivered(ctx context.Context, msg Message, now time.Time) error {
+	query := fmt.Sprintf("UPDATE %s SET attempts = ?, delivered_at = ? WHERE id = ?", o.table)
+	_, err := o.db.Exec(db.UseRebind(ctx), query, msg.Attempts+1, now, msg.ID)
+	return err
+}
+
+// failed schedules the next attempt of a message
+func (o *Outbox) failed(ctx context.Context, msg Message, cause error, next time.Time) error {
+	query := fmt.Sprintf("UPDATE %s SET attempts = ?, last_error = ?, next_attempt_at = ? WHERE id = ?", o.table)
+	_, err := o.db.Exec(db.UseRebind(ctx), query, msg.Attempts+1, cause.Error(), next, msg.ID)
+	return err
+}
+
+// dead marks a message as dead, it is not attempted anymore
+func (o *Outbox) dead(ctx context.Context, msg Message, cause error, now time.Time) error {
+	query := fmt.Sprintf("UPDATE %s SET attempts = ?, last_error = ?, dead_at = ? WHERE id = ?", o.table)
+	_, err := o.db.Exec(db.UseRebind(ctx), query, msg.Attempts+1, cause.Error(), now, msg.ID)
+	return err
+}
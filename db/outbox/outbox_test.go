@@ -0,0 +1,189 @@
+package outbox
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/org39/gopkg/db"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type OutboxTestSuite struct {
+	suite.Suite
+	db     *db.DB
+	outbox *Outbox
+	mock   sqlmock.Sqlmock
+}
+
+// SetupTest will be run before every test in the suite.
+func (s *OutboxTestSuite) SetupTest() {
+	mockdb, mock, err := sqlmock.New(
+		sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	mock.MatchExpectationsInOrder(true)
+	s.db = &db.DB{DB: mockdb, Dialect: db.Postgres}
+	s.outbox, err = New(s.db)
+	if err != nil {
+		panic(err)
+	}
+	s.mock = mock
+}
+
+// TestEnqueue will test the message written within the transaction of the caller.
+func (s *OutboxTestSuite) TestEnqueue() {
+	ctx := context.Background()
+
+	// mock
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec("INSERT INTO outbox (topic, payload, attempts, created_at, next_attempt_at) VALUES ($1, $2, 0, $3, $4)").
+		WithArgs("user.created", []byte(`{"id":1}`), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectCommit()
+
+	// test
+	err := s.db.WithTransaction(ctx, func(txCtx context.Context) error {
+		return s.outbox.Enqueue(txCtx, "user.created", []byte(`{"id":1}`))
+	})
+	assert.NoError(s.T(), err)
+
+	err = s.outbox.Enqueue(ctx, "user.created", []byte(`{"id":1}`))
+	assert.ErrorIs(s.T(), err, ErrNoTransaction)
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
+
+// TestPoll will test the pending messages delivered, retried or dead-lettered.
+func (s *OutboxTestSuite) TestPoll() {
+	ctx := context.Background()
+	publisher := NewMemoryPublisher()
+	publisher.FailWith(func(msg Message) error {
+		if msg.ID == 1 {
+			return nil
+		}
+		return fmt.Errorf("broker unavailable")
+	})
+	relay, err := NewRelay(s.outbox, publisher, WithBatchSize(10), WithMaxAttempts(3))
+	if err != nil {
+		panic(err)
+	}
+
+	// mock
+	now := time.Now()
+	s.expectClaim(10, sqlmock.NewRows([]string{"id", "topic", "payload", "attempts", "created_at"}).
+		AddRow(1, "user.created", []byte(`{"id":1}`), 0, now).
+		AddRow(2, "user.created", []byte(`{"id":2}`), 0, now).
+		AddRow(3, "user.created", []byte(`{"id":3}`), 2, now), 1, 2, 3)
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec("UPDATE outbox SET attempts = $1, delivered_at = $2 WHERE id = $3").
+		WithArgs(1, sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec("UPDATE outbox SET attempts = $1, last_error = $2, next_attempt_at = $3 WHERE id = $4").
+		WithArgs(1, "broker unavailable", sqlmock.AnyArg(), 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec("UPDATE outbox SET attempts = $1, last_error = $2, dead_at = $3 WHERE id = $4").
+		WithArgs(3, "broker unavailable", sqlmock.AnyArg(), 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+
+	// test
+	n, err := relay.Poll(ctx)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), 3, n)
+
+	published := publisher.Messages()
+	if assert.Len(s.T(), published, 1) {
+		assert.Equal(s.T(), int64(1), published[0].ID)
+		assert.Equal(s.T(), []byte(`{"id":1}`), published[0].Payload)
+	}
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
+
+// TestPollRecordFailure will test a failed record not undoing the records of the other messages.
+func (s *OutboxTestSuite) TestPollRecordFailure() {
+	ctx := context.Background()
+	publisher := NewMemoryPublisher()
+	relay, err := NewRelay(s.outbox, publisher, WithBatchSize(10))
+	if err != nil {
+		panic(err)
+	}
+
+	// mock
+	now := time.Now()
+	delivered := "UPDATE outbox SET attempts = $1, delivered_at = $2 WHERE id = $3"
+	s.expectClaim(10, sqlmock.NewRows([]string{"id", "topic", "payload", "attempts", "created_at"}).
+		AddRow(1, "user.created", []byte(`{"id":1}`), 0, now).
+		AddRow(2, "user.created", []byte(`{"id":2}`), 0, now), 1, 2)
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(delivered).WithArgs(1, sqlmock.AnyArg(), 1).WillReturnError(errors.New("connection reset"))
+	s.mock.ExpectRollback()
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(delivered).WithArgs(1, sqlmock.AnyArg(), 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+
+	// test
+	n, err := relay.Poll(ctx)
+	assert.EqualError(s.T(), err, "connection reset")
+	assert.Equal(s.T(), 2, n)
+	assert.Len(s.T(), publisher.Messages(), 2)
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
+
+// expectClaim expects the pending messages to be claimed within a transaction
+func (s *OutboxTestSuite) expectClaim(limit int, rows *sqlmock.Rows, ids ...driver.Value) {
+	placeholders := make([]string, len(ids))
+	for i := range ids {
+		placeholders[i] = fmt.Sprintf("$%d", i+2)
+	}
+
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT id, topic, payload, attempts, created_at FROM outbox WHERE delivered_at IS NULL AND dead_at IS NULL AND next_attempt_at <= $1 ORDER BY id LIMIT $2 FOR UPDATE SKIP LOCKED").
+		WithArgs(sqlmock.AnyArg(), limit).
+		WillReturnRows(rows)
+	s.mock.ExpectExec(fmt.Sprintf("UPDATE outbox SET next_attempt_at = $1 WHERE id IN (%s)", strings.Join(placeholders, ", "))).
+		WithArgs(append([]driver.Value{sqlmock.AnyArg()}, ids...)...).
+		WillReturnResult(sqlmock.NewResult(0, int64(len(ids))))
+	s.mock.ExpectCommit()
+}
+
+func TestOutboxTestSuite(t *testing.T) {
+	suite.Run(t, new(OutboxTestSuite))
+}
+
+func TestBackoff(t *testing.T) {
+	relay, err := NewRelay(nil, nil, WithRetryDelay(time.Second, 5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, time.Second, relay.backoff(1))
+	assert.Equal(t, 4*time.Second, relay.backoff(3))
+	assert.Equal(t, 5*time.Second, relay.backoff(10))
+}
+
+func TestRelayOptions(t *testing.T) {
+	_, err := NewRelay(nil, nil, WithRetryDelay(0, time.Second))
+	assert.Error(t, err)
+	_, err = NewRelay(nil, nil, WithRetryDelay(time.Minute, time.Second))
+	assert.Error(t, err)
+	_, err = NewRelay(nil, nil, WithLease(-time.Second))
+	assert.Error(t, err)
+
+	relay, err := NewRelay(nil, nil, WithLease(30*time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, 30*time.Second, relay.Lease)
+}
@@ -0,0 +1,196 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/org39/gopkg/log"
+)
+
+// Publisher publishes the messages of the outbox
+type Publisher interface {
+	// Publish publishes a message, a message may be published more than once.
+	Publish(ctx context.Context, msg Message) error
+}
+
+// Relay polls the pending messages of an outbox and hands them to a publisher
+type Relay struct {
+	outbox    *Outbox
+	publisher Publisher
+
+	// BatchSize is the maximum number of messages handled by a poll.
+	BatchSize int
+	// PollInterval is the interval between two polls.
+	PollInterval time.Duration
+	// MaxAttempts is the number of attempts after which a message is dead-lettered.
+	MaxAttempts int
+	// RetryDelay is the delay before the first retry of a message, doubled on every retry.
+	RetryDelay time.Duration
+	// MaxRetryDelay is the maximum delay between two attempts of a message.
+	MaxRetryDelay time.Duration
+	// Lease is the duration for which the messages of a poll are claimed, they are attempted
+	// again once it has expired if the outcome of their attempt has not been recorded.
+	Lease time.Duration
+}
+
+// NewRelay creates a new relay of the outbox to the publisher
+func NewRelay(o *Outbox, publisher Publisher, options ...func(*Relay) error) (*Relay, error) {
+	r := &Relay{
+		outbox:    o,
+		publisher: publisher,
+		// 100 messages per poll by default
+		BatchSize: 100,
+		// poll every second by default
+		PollInterval: time.Second,
+		// dead-letter after 10 attempts by default
+		MaxAttempts: 10,
+		// retry after 1sec, up to 10min by default
+		RetryDelay:    time.Second,
+		MaxRetryDelay: 10 * time.Minute,
+		// claim the messages for 1min by default
+		Lease: time.Minute,
+	}
+
+	for _, option := range options {
+		err := option(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// WithBatchSize is a relay option that sets the maximum number of messages handled by a poll
+func WithBatchSize(size int) func(*Relay) error {
+	return func(r *Relay) error {
+		if size < 1 {
+			return fmt.Errorf("outbox: invalid batch size %d", size)
+		}
+		r.BatchSize = size
+		return nil
+	}
+}
+
+// WithPollInterval is a relay option that sets the interval between two polls
+func WithPollInterval(d time.Duration) func(*Relay) error {
+	return func(r *Relay) error {
+		if d <= 0 {
+			return fmt.Errorf("outbox: invalid poll interval %s", d)
+		}
+		r.PollInterval = d
+		return nil
+	}
+}
+
+// WithMaxAttempts is a relay option that sets the number of attempts after which a message is dead-lettered
+func WithMaxAttempts(attempts int) func(*Relay) error {
+	return func(r *Relay) error {
+		if attempts < 1 {
+			return fmt.Errorf("outbox: invalid max attempts %d", attempts)
+		}
+		r.MaxAttempts = attempts
+		return nil
+	}
+}
+
+// WithRetryDelay is a relay option that sets the delays between two attempts of a message
+func WithRetryDelay(delay, maxDelay time.Duration) func(*Relay) error {
+	return func(r *Relay) error {
+		if delay <= 0 || maxDelay < delay {
+			return fmt.Errorf("outbox: invalid retry delays %s and %s", delay, maxDelay)
+		}
+		r.RetryDelay = delay
+		r.MaxRetryDelay = maxDelay
+		return nil
+	}
+}
+
+// WithLease is a relay option that sets the duration for which the messages of a poll are claimed
+func WithLease(d time.Duration) func(*Relay) error {
+	return func(r *Relay) error {
+		if d <= 0 {
+			return fmt.Errorf("outbox: invalid lease %s", d)
+		}
+		r.Lease = d
+		return nil
+	}
+}
+
+// Run polls the outbox until the context is done
+func (r *Relay) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		n, err := r.Poll(ctx)
+		if err != nil {
+			log.LoggerWithSpan(ctx).WithError(err).Error("outbox: poll failed")
+		}
+
+		// poll again right away while the batches are full
+		if err == nil && n == r.BatchSize {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll claims a batch of pending messages, hands them to the publisher and records their outcome,
+// and returns the number of messages handled. The messages are claimed within a short transaction,
+// and published outside of it, the outcome of every message being recorded on its own:
+// a message whose outcome can not be recorded is attempted again once its lease has expired.
+func (r *Relay) Poll(ctx context.Context) (int, error) {
+	now := time.Now().UTC()
+	messages, err := r.outbox.claim(ctx, now, now.Add(r.Lease), r.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	var first error
+	for _, msg := range messages {
+		if err := r.handle(ctx, msg); err != nil {
+			log.LoggerWithSpan(ctx).WithError(err).Errorf("outbox: can not record the attempt of message %d of %s", msg.ID, msg.Topic)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return len(messages), first
+}
+
+// handle publishes a message, and records the outcome
+func (r *Relay) handle(ctx context.Context, msg Message) error {
+	perr := r.publisher.Publish(ctx, msg)
+	now := time.Now().UTC()
+	switch {
+	case perr == nil:
+		return r.outbox.delivered(ctx, msg, now)
+	case msg.Attempts+1 >= r.MaxAttempts:
+		log.LoggerWithSpan(ctx).WithError(perr).Errorf("outbox: message %d of %s is dead-lettered", msg.ID, msg.Topic)
+		return r.outbox.dead(ctx, msg, perr, now)
+	default:
+		return r.outbox.failed(ctx, msg, perr, now.Add(r.backoff(msg.Attempts+1)))
+	}
+}
+
+// backoff returns the delay before the given retry
+func (r *Relay) backoff(retry int) time.Duration {
+	d := r.RetryDelay
+	for i := 1; i < retry && d < r.MaxRetryDelay; i++ {
+		d *= 2
+	}
+	if d > r.MaxRetryDelay {
+		d = r.MaxRetryDelay
+	}
+	return d
+}
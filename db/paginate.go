@@ -0,0 +1,331 @@
+package db
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ErrInvalidCursor is returned when a pagination cursor is malformed or its signature does not match
+var ErrInvalidCursor = errors.New("db: invalid cursor")
+
+// SortColumn is a column the results of a paginated query are sorted by
+type SortColumn struct {
+	// Name is the name of the column in the results of the query.
+	// It is written in the query as is, and must not come from user input.
+	Name string
+	// Desc sorts the column in descending order
+	Desc bool
+}
+
+// Pager paginates the results of queries by keyset: a page starts after the sort keys
+// of the last item of the previous page, instead of skipping the rows before it.
+type Pager struct {
+	// Columns are the columns the results are sorted by, they must identify a row uniquely
+	Columns []SortColumn
+	// Limit is the maximum number of items of a page
+	Limit int
+	// Secret is the key signing the cursors, so that clients can not forge them
+	Secret []byte
+}
+
+// Page is a page of the results of a query
+type Page[T any] struct {
+	Items []T
+	// Next and Prev are the cursors of the next and previous pages, empty if there is none
+	Next string
+	Prev string
+}
+
+// cursor is the position of a page in the results of a query
+type cursor struct {
+	// Prev is true if the page ends before the keys, instead of starting after them
+	Prev bool              `json:"p,omitempty"`
+	Keys []json.RawMessage `json:"k"`
+}
+
+// Paginate executes a query and returns the page of its results at the cursor,
+// the first page if the cursor is empty. The items are scanned like Select.
+// The query is wrapped as a subquery sorted by the columns of the pager,
+// its arguments must be positional, and its ? placeholders are rewritten for the dialect like with UseRebind.
+func Paginate[T any](ctx context.Context, db *DB, p Pager, cursorToken string, query string, args ...interface{}) (*Page[T], error) {
+	if len(p.Columns) == 0 || p.Limit < 1 || len(p.Secret) == 0 {
+		return nil, fmt.Errorf("db: invalid pager, it needs columns, a limit and a secret")
+	}
+
+	ks, err := newKeyset[T](p.Columns)
+	if err != nil {
+		return nil, err
+	}
+
+	var c *cursor
+	if cursorToken != "" {
+		if c, err = p.decode(cursorToken); err != nil {
+			return nil, err
+		}
+		var keys []interface{}
+		if keys, err = ks.decode(c.Keys); err != nil {
+			return nil, err
+		}
+		// the keys are appended to a copy, not to the slice of the caller
+		args = append(args[:len(args):len(args)], p.where(db.Dialect, keys)...)
+	}
+	prev := c != nil && c.Prev
+
+	items, err := Select[T](UseRebind(ctx), db, p.query(db.Dialect, query, c), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	// a page is fetched with an extra item, to know if there is more after it
+	more := len(items) > p.Limit
+	if more {
+		items = items[:p.Limit]
+	}
+	if prev {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+	}
+
+	page := &Page[T]{Items: items}
+	if len(items) == 0 {
+		return page, nil
+	}
+
+	// a page reached from the next one has a next page, and the other way around
+	hasNext, hasPrev := more, c != nil
+	if prev {
+		hasNext, hasPrev = true, more
+	}
+	if hasNext {
+		if page.Next, err = p.encode(false, ks.encode(items[len(items)-1])); err != nil {
+			return nil, err
+		}
+	}
+	if hasPrev {
+		if page.Prev, err = p.encode(true, ks.encode(items[0])); err != nil {
+			return nil, err
+		}
+	}
+
+	return page, nil
+}
+
+// query returns the query of the page at the cursor
+func (p Pager) query(d Dialect, query string, c *cursor) string {
+	prev := c != nil && c.Prev
+
+	var b strings.Builder
+	if d == SQLServer {
+		fmt.Fprintf(&b, "SELECT TOP (%d) * FROM (%s) AS page", p.Limit+1, query)
+	} else {
+		fmt.Fprintf(&b, "SELECT * FROM (%s) AS page", query)
+	}
+
+	if c != nil {
+		b.WriteString(" WHERE ")
+		b.WriteString(p.condition(d, prev))
+	}
+
+	// the previous page is fetched in reverse order
+	b.WriteString(" ORDER BY ")
+	for i, col := range p.Columns {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(col.Name)
+		if col.Desc != prev {
+			b.WriteString(" DESC")
+		}
+	}
+
+	if d != SQLServer {
+		fmt.Fprintf(&b, " LIMIT %d", p.Limit+1)
+	}
+	return b.String()
+}
+
+// uniform reports whether the keys can be compared as a row value
+func (p Pager) uniform(d Dialect) bool {
+	if d == SQLServer {
+		return false
+	}
+	for _, col := range p.Columns {
+		if col.Desc != p.Columns[0].Desc {
+			return false
+		}
+	}
+	return true
+}
+
+// op returns the operator selecting the rows after the key of a column, or before it for the previous page
+func op(col SortColumn, prev bool) string {
+	if col.Desc == prev {
+		return ">"
+	}
+	return "<"
+}
+
+// condition returns the condition selecting the rows after the keys, or before them for the previous page.
+// The keys are compared as a row value, `(a, b) > (?, ?)`, if all columns are sorted in the same order,
+// or column by column, `a > ? OR (a = ? AND b > ?)`, otherwise.
+func (p Pager) condition(d Dialect, prev bool) string {
+	if p.uniform(d) {
+		names := make([]string, len(p.Columns))
+		placeholders := make([]string, len(p.Columns))
+		for i, col := range p.Columns {
+			names[i] = col.Name
+			placeholders[i] = "?"
+		}
+		return fmt.Sprintf("(%s) %s (%s)", strings.Join(names, ", "), op(p.Columns[0], prev), strings.Join(placeholders, ", "))
+	}
+
+	terms := make([]string, len(p.Columns))
+	for i, col := range p.Columns {
+		conds := make([]string, 0, i+1)
+		for _, eq := range p.Columns[:i] {
+			conds = append(conds, eq.Name+" = ?")
+		}
+		conds = append(conds, fmt.Sprintf("%s %s ?", col.Name, op(col, prev)))
+		terms[i] = "(" + strings.Join(conds, " AND ") + ")"
+	}
+	return "(" + strings.Join(terms, " OR ") + ")"
+}
+
+// where returns the arguments of the condition for the keys
+func (p Pager) where(d Dialect, keys []interface{}) []interface{} {
+	if p.uniform(d) {
+		return keys
+	}
+
+	args := make([]interface{}, 0, len(keys)*(len(keys)+1)/2)
+	for i := range keys {
+		args = append(args, keys[:i+1]...)
+	}
+	return args
+}
+
+// encode returns the signed token of a cursor
+func (p Pager) encode(prev bool, keys []json.RawMessage) (string, error) {
+	payload, err := json.Marshal(cursor{Prev: prev, Keys: keys})
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(p.sign(payload)), nil
+}
+
+// decode verifies the signature of a token and returns its cursor
+func (p Pager) decode(token string) (*cursor, error) {
+	enc := base64.RawURLEncoding
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, ErrInvalidCursor
+	}
+	payload, err := enc.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	mac, err := enc.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(mac, p.sign(payload)) {
+		return nil, ErrInvalidCursor
+	}
+
+	c := &cursor{}
+	if err := json.Unmarshal(payload, c); err != nil || len(c.Keys) != len(p.Columns) {
+		return nil, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+func (p Pager) sign(payload []byte) []byte {
+	h := hmac.New(sha256.New, p.Secret)
+	h.Write(payload)
+	return h.Sum(nil)
+}
+
+// keyset reads the sort keys of values of T
+type keyset struct {
+	// indexes are the field indexes of the sort columns, nil if T is a key as a whole
+	indexes [][]int
+	// types are the types of the keys, to decode them from a cursor
+	types []reflect.Type
+	// ptr is true if T is a pointer to the struct
+	ptr bool
+}
+
+func newKeyset[T any](columns []SortColumn) (*keyset, error) {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	ks := &keyset{}
+
+	if t.Kind() == reflect.Ptr && !isLeaf(t.Elem()) {
+		t = t.Elem()
+		ks.ptr = true
+	}
+
+	// T is the key as a whole
+	if isLeaf(t) {
+		if len(columns) != 1 {
+			return nil, fmt.Errorf("db: %d sort columns can not be read from %s", len(columns), t)
+		}
+		ks.types = []reflect.Type{t}
+		return ks, nil
+	}
+
+	fields := fieldsOf(t)
+	for _, col := range columns {
+		at, ok := fields.byName[col.Name]
+		if !ok {
+			return nil, fmt.Errorf("db: sort column %s has no field in %s", col.Name, t)
+		}
+		index := fields.fields[at].index
+		ks.indexes = append(ks.indexes, index)
+		ks.types = append(ks.types, t.FieldByIndex(index).Type)
+	}
+	return ks, nil
+}
+
+// encode returns the encoded sort keys of a value
+func (ks *keyset) encode(v interface{}) []json.RawMessage {
+	rv := reflect.ValueOf(v)
+	if ks.ptr {
+		rv = rv.Elem()
+	}
+
+	var values []interface{}
+	if ks.indexes == nil {
+		values = []interface{}{rv.Interface()}
+	} else {
+		for _, index := range ks.indexes {
+			values = append(values, fieldValue(rv, index))
+		}
+	}
+
+	keys := make([]json.RawMessage, len(values))
+	for i, value := range values {
+		// the keys are scanned values, they can be encoded
+		keys[i], _ = json.Marshal(value)
+	}
+	return keys
+}
+
+// decode returns the sort keys of a cursor, with the types of their fields
+func (ks *keyset) decode(keys []json.RawMessage) ([]interface{}, error) {
+	values := make([]interface{}, len(keys))
+	for i, key := range keys {
+		v := reflect.New(ks.types[i])
+		if err := json.Unmarshal(key, v.Interface()); err != nil {
+			return nil, ErrInvalidCursor
+		}
+		values[i] = v.Elem().Interface()
+	}
+	return values, nil
+}
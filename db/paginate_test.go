@@ -0,0 +1,118 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPagerQuery(t *testing.T) {
+	base := "SELECT * FROM users"
+	mixed := Pager{Columns: []SortColumn{{Name: "created_at", Desc: true}, {Name: "id"}}, Limit: 10}
+	uniform := Pager{Columns: []SortColumn{{Name: "created_at"}, {Name: "id"}}, Limit: 10}
+
+	tests := []struct {
+		name    string
+		pager   Pager
+		dialect Dialect
+		cursor  *cursor
+		want    string
+	}{
+		{
+			name:    "first page",
+			pager:   uniform,
+			dialect: MySQL,
+			want:    "SELECT * FROM (SELECT * FROM users) AS page ORDER BY created_at, id LIMIT 11",
+		},
+		{
+			name:    "row value",
+			pager:   uniform,
+			dialect: Postgres,
+			cursor:  &cursor{},
+			want:    "SELECT * FROM (SELECT * FROM users) AS page WHERE (created_at, id) > (?, ?) ORDER BY created_at, id LIMIT 11",
+		},
+		{
+			name:    "previous page",
+			pager:   uniform,
+			dialect: Postgres,
+			cursor:  &cursor{Prev: true},
+			want:    "SELECT * FROM (SELECT * FROM users) AS page WHERE (created_at, id) < (?, ?) ORDER BY created_at DESC, id DESC LIMIT 11",
+		},
+		{
+			name:    "mixed order",
+			pager:   mixed,
+			dialect: MySQL,
+			cursor:  &cursor{},
+			want:    "SELECT * FROM (SELECT * FROM users) AS page WHERE ((created_at < ?) OR (created_at = ? AND id > ?)) ORDER BY created_at DESC, id LIMIT 11",
+		},
+		{
+			name:    "sqlserver",
+			pager:   uniform,
+			dialect: SQLServer,
+			cursor:  &cursor{},
+			want:    "SELECT TOP (11) * FROM (SELECT * FROM users) AS page WHERE ((created_at > ?) OR (created_at = ? AND id > ?)) ORDER BY created_at, id",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.pager.query(tt.dialect, base, tt.cursor))
+		})
+	}
+
+	assert.Equal(t, []interface{}{"t", "t", 1}, mixed.where(MySQL, []interface{}{"t", 1}))
+}
+
+// TestPaginate will test pages navigated with their cursors.
+func (s *DBTestSuite) TestPaginate() {
+	ctx := context.Background()
+	type item struct {
+		ID   int64  `db:"id"`
+		Name string `db:"name"`
+	}
+	p := Pager{Columns: []SortColumn{{Name: "id"}}, Limit: 2, Secret: []byte("secret")}
+
+	// mock
+	base := "SELECT `id`, `name` FROM `users` WHERE `name` <> ?"
+	columns := []string{"id", "name"}
+	s.mock.ExpectQuery("SELECT * FROM (" + base + ") AS page ORDER BY id LIMIT 3").
+		WithArgs("root").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(1, "a").AddRow(2, "b").AddRow(3, "c"))
+	s.mock.ExpectQuery("SELECT * FROM ("+base+") AS page WHERE (id) > (?) ORDER BY id LIMIT 3").
+		WithArgs("root", 2).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(3, "c"))
+	s.mock.ExpectQuery("SELECT * FROM ("+base+") AS page WHERE (id) < (?) ORDER BY id DESC LIMIT 3").
+		WithArgs("root", 3).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(2, "b").AddRow(1, "a"))
+
+	// test
+	page, err := Paginate[item](ctx, s.db, p, "", base, "root")
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), []item{{1, "a"}, {2, "b"}}, page.Items)
+	assert.NotEmpty(s.T(), page.Next)
+	assert.Empty(s.T(), page.Prev)
+
+	page, err = Paginate[item](ctx, s.db, p, page.Next, base, "root")
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), []item{{3, "c"}}, page.Items)
+	assert.Empty(s.T(), page.Next)
+	assert.NotEmpty(s.T(), page.Prev)
+
+	page, err = Paginate[item](ctx, s.db, p, page.Prev, base, "root")
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), []item{{1, "a"}, {2, "b"}}, page.Items)
+	assert.NotEmpty(s.T(), page.Next)
+	assert.Empty(s.T(), page.Prev)
+
+	// a tampered cursor is rejected
+	_, err = Paginate[item](ctx, s.db, p, "eyJrIjpbIjEwMCJdfQ."+page.Next[len(page.Next)-43:], base, "root")
+	assert.ErrorIs(s.T(), err, ErrInvalidCursor)
+
+	other := p
+	other.Secret = []byte("other")
+	_, err = Paginate[item](ctx, s.db, other, page.Next, base, "root")
+	assert.ErrorIs(s.T(), err, ErrInvalidCursor)
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
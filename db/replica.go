@@ -0,0 +1,174 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/org39/gopkg/log"
+)
+
+// ReplicaPolicy decides which healthy replica serves a read
+type ReplicaPolicy int
+
+const (
+	// RoundRobin picks the healthy replicas in turn
+	RoundRobin ReplicaPolicy = iota
+	// LeastConnections picks the healthy replica with the fewest connections in use
+	LeastConnections
+)
+
+// replica is a read replica of the primary database
+type replica struct {
+	db      *sql.DB
+	healthy int32
+}
+
+func (r *replica) isHealthy() bool {
+	return atomic.LoadInt32(&r.healthy) == 1
+}
+
+func (r *replica) setHealthy(healthy bool) (changed bool) {
+	v := int32(0)
+	if healthy {
+		v = 1
+	}
+	return atomic.SwapInt32(&r.healthy, v) != v
+}
+
+// replicaSet is the set of read replicas and their health checker
+type replicaSet struct {
+	replicas []*replica
+	policy   ReplicaPolicy
+	next     uint32
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newReplicaSet(dbs []*sql.DB, policy ReplicaPolicy) *replicaSet {
+	rs := &replicaSet{
+		policy: policy,
+		stop:   make(chan struct{}),
+	}
+	for _, db := range dbs {
+		// replicas are assumed healthy until the first health check
+		rs.replicas = append(rs.replicas, &replica{db: db, healthy: 1})
+	}
+	return rs
+}
+
+// pick returns a healthy replica, or nil if there is none
+func (rs *replicaSet) pick() *sql.DB {
+	switch rs.policy {
+	case LeastConnections:
+		var picked *replica
+		inUse := 0
+		for _, r := range rs.replicas {
+			if !r.isHealthy() {
+				continue
+			}
+			if n := r.db.Stats().InUse; picked == nil || n < inUse {
+				picked, inUse = r, n
+			}
+		}
+		if picked != nil {
+			return picked.db
+		}
+	default:
+		start := atomic.AddUint32(&rs.next, 1)
+		for i := range rs.replicas {
+			r := rs.replicas[(int(start)+i)%len(rs.replicas)]
+			if r.isHealthy() {
+				return r.db
+			}
+		}
+	}
+
+	return nil
+}
+
+// healthCheck pings the replicas periodically until stopped
+func (rs *replicaSet) healthCheck(interval time.Duration) {
+	rs.wg.Add(1)
+	go func() {
+		defer rs.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			// the pings time out before the next check is due
+			rs.ping(interval / 2)
+
+			select {
+			case <-rs.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// ping updates the health of every replica, pinging them concurrently
+// so that a hung replica does not delay the health of the others
+func (rs *replicaSet) ping(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i, r := range rs.replicas {
+		wg.Add(1)
+		go func(i int, r *replica) {
+			defer wg.Done()
+
+			err := r.db.PingContext(ctx)
+			if r.setHealthy(err == nil) {
+				if err != nil {
+					log.WithError(err).Warnf("db: replica %d is unhealthy", i)
+				} else {
+					log.Infof("db: replica %d is healthy", i)
+				}
+			}
+		}(i, r)
+	}
+	wg.Wait()
+}
+
+// close stops the health checker and closes the replicas
+func (rs *replicaSet) close() error {
+	close(rs.stop)
+	rs.wg.Wait()
+
+	var err error
+	for _, r := range rs.replicas {
+		if cerr := r.db.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// UsePrimary returns a context that forces reads on the primary database,
+// for flows that must read their own writes
+func UsePrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey, true)
+}
+
+// reader returns the database handle serving a read outside a transaction
+func (db *DB) reader(ctx context.Context) *sql.DB {
+	if db.replicas == nil {
+		return db.DB
+	}
+	if primary, _ := ctx.Value(primaryKey).(bool); primary {
+		return db.DB
+	}
+	if r := db.replicas.pick(); r != nil {
+		return r
+	}
+
+	// fall back to the primary if no replica is healthy
+	return db.DB
+}
@@ -0,0 +1,177 @@
+package db
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+func init() {
+	// the driver values which are not registered by gob
+	gob.Register(time.Time{})
+}
+
+// resultRows are the rows read by Get, Select and Stream, from the database or from the cache
+type resultRows interface {
+	Scanable
+	Columns() ([]string, error)
+	Next() bool
+	Err() error
+	Close() error
+}
+
+// cachedResult is the result of a query, made of driver values
+type cachedResult struct {
+	Columns []string
+	Rows    [][]interface{}
+}
+
+// collectResult reads and closes the rows of a query
+func collectResult(rows *sql.Rows) (*cachedResult, error) {
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	res := &cachedResult{Columns: columns}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		res.Rows = append(res.Rows, values)
+	}
+
+	return res, rows.Close()
+}
+
+func encodeResult(res *cachedResult) ([]byte, error) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(res); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func decodeResult(value []byte) (*cachedResult, error) {
+	res := &cachedResult{}
+	if err := gob.NewDecoder(bytes.NewReader(value)).Decode(res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+var errRowsClosed = errors.New("db: rows are closed")
+
+// cachedRows are the rows of a cached result, scanned like *sql.Rows
+type cachedRows struct {
+	res    *cachedResult
+	row    []interface{}
+	next   int
+	closed bool
+}
+
+func newCachedRows(res *cachedResult) *cachedRows {
+	return &cachedRows{res: res}
+}
+
+// Columns returns the names of the columns
+func (r *cachedRows) Columns() ([]string, error) {
+	if r.closed {
+		return nil, errRowsClosed
+	}
+	return r.res.Columns, nil
+}
+
+// Next prepares the next row for Scan, it returns false once the rows are exhausted
+func (r *cachedRows) Next() bool {
+	if r.closed || r.next >= len(r.res.Rows) {
+		r.Close()
+		return false
+	}
+	r.row = r.res.Rows[r.next]
+	r.next++
+	return true
+}
+
+// Scan copies the columns of the current row into the values pointed at by dest
+func (r *cachedRows) Scan(dest ...interface{}) error {
+	if r.closed || r.row == nil {
+		return errors.New("db: Scan called without calling Next")
+	}
+	if len(dest) != len(r.row) {
+		return fmt.Errorf("db: expected %d destination arguments in Scan, not %d", len(r.row), len(dest))
+	}
+	for i, src := range r.row {
+		if err := convertAssign(dest[i], src); err != nil {
+			return fmt.Errorf("db: can not scan column %d (%q): %w", i, r.res.Columns[i], err)
+		}
+	}
+	return nil
+}
+
+// Err returns nil, the rows of a cached result can not fail
+func (r *cachedRows) Err() error {
+	return nil
+}
+
+// Close closes the rows, it can be called several times
+func (r *cachedRows) Close() error {
+	r.closed = true
+	r.row = nil
+	return nil
+}
+
+// convertAssign copies a cached driver value into a destination, like the rows of database/sql
+func convertAssign(dest, src interface{}) error {
+	// the result is shared, its bytes are copied
+	if b, ok := src.([]byte); ok {
+		src = append([]byte(nil), b...)
+	}
+
+	switch d := dest.(type) {
+	case sql.Scanner:
+		return d.Scan(src)
+	case *interface{}:
+		*d = src
+		return nil
+	}
+
+	dv := reflect.ValueOf(dest)
+	if dv.Kind() != reflect.Ptr || dv.IsNil() {
+		return fmt.Errorf("destination not a pointer: %T", dest)
+	}
+	return convertValue(dv.Elem(), src)
+}
+
+// convertValue copies a driver value into the value of a destination, allocating its pointers
+func convertValue(dest reflect.Value, src interface{}) error {
+	if src == nil {
+		switch dest.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+			dest.Set(reflect.Zero(dest.Type()))
+			return nil
+		}
+		return fmt.Errorf("converting NULL to %s is unsupported", dest.Type())
+	}
+
+	if dest.Kind() == reflect.Ptr {
+		v := reflect.New(dest.Type().Elem())
+		if err := convertAssign(v.Interface(), src); err != nil {
+			return err
+		}
+		dest.Set(v)
+		return nil
+	}
+	return assign(dest, src)
+}
@@ -0,0 +1,134 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"regexp"
+	"time"
+)
+
+// RetryPolicy decides how WithRetryTransaction retries a transaction
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first one.
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, it is doubled on every retry.
+	BaseDelay time.Duration
+	// MaxDelay is the maximum delay between two attempts, 0 leaves it unbounded.
+	MaxDelay time.Duration
+	// Retryable reports whether a failed transaction is worth a retry.
+	Retryable func(error) bool
+}
+
+// DefaultRetryPolicy returns the retry policy used when none is configured
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 5,
+		BaseDelay:   10 * time.Millisecond,
+		MaxDelay:    time.Second,
+		Retryable:   IsRetryable,
+	}
+}
+
+// WithRetryPolicy is a database option that sets the retry policy of WithRetryTransaction
+func WithRetryPolicy(policy RetryPolicy) func(*DB) error {
+	return func(db *DB) error {
+		if policy.MaxAttempts < 1 {
+			return fmt.Errorf("db: invalid max attempts %d", policy.MaxAttempts)
+		}
+		if policy.Retryable == nil {
+			policy.Retryable = IsRetryable
+		}
+		db.RetryPolicy = policy
+		return nil
+	}
+}
+
+// backoff returns the jittered delay before the given retry
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < retry && (p.MaxDelay <= 0 || d < p.MaxDelay) && d <= math.MaxInt64/2; i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+
+	// half of the delay is fixed, the other half is random
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1)) //nolint:gosec // jitter needs no secure random
+}
+
+var (
+	// retryableSQLStates are serialization failure and deadlock detected
+	retryableSQLStates = map[string]bool{
+		"40001": true,
+		"40P01": true,
+	}
+	// retryableMySQLErrors are deadlock found when trying to get lock
+	retryableMySQLErrors = map[string]bool{
+		"1213": true,
+	}
+
+	sqlStatePattern   = regexp.MustCompile(`SQLSTATE (\w{5})`)
+	mysqlErrorPattern = regexp.MustCompile(`Error (\d+)`)
+)
+
+// IsRetryable reports whether the error is a serialization failure or a deadlock.
+// It recognizes errors implementing SQLState() string, and the error messages
+// of the common MySQL and PostgreSQL drivers.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var stateErr interface{ SQLState() string }
+	if errors.As(err, &stateErr) && retryableSQLStates[stateErr.SQLState()] {
+		return true
+	}
+
+	msg := err.Error()
+	if m := sqlStatePattern.FindStringSubmatch(msg); m != nil && retryableSQLStates[m[1]] {
+		return true
+	}
+	if m := mysqlErrorPattern.FindStringSubmatch(msg); m != nil && retryableMySQLErrors[m[1]] {
+		return true
+	}
+
+	return false
+}
+
+// WithRetryTransaction executes a function within a transaction like WithTransaction,
+// and re-runs the whole transaction when it fails with a retryable error.
+// A nested call is not retried by itself, the outermost transaction is the unit of retry.
+func (db *DB) WithRetryTransaction(ctx context.Context, fn func(context.Context) error, opts ...TxOption) error {
+	if _, ok := txFromContext(ctx); ok {
+		return db.WithTransaction(ctx, fn, opts...)
+	}
+
+	policy := db.RetryPolicy
+	if policy.MaxAttempts < 1 {
+		policy = DefaultRetryPolicy()
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := db.WithTransaction(ctx, fn, opts...)
+		if err == nil || attempt >= policy.MaxAttempts || !policy.Retryable(err) {
+			return err
+		}
+
+		// wait for the next attempt or context cancled
+		timer := time.NewTimer(policy.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%v: %w", err, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
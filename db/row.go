@@ -0,0 +1,41 @@
+package db
+
+import (
+	"database/sql"
+
+	"github.com/org39/gopkg/crypt"
+)
+
+// Row is the result of QueryRowx. Like *sql.Row, its error is deferred until Scan,
+// the errors of the arguments and the interceptors being reported without querying the database.
+type Row struct {
+	row  *sql.Row
+	res  *cachedResult
+	keys *crypt.KeyRing
+	err  error
+}
+
+// Scan copies the columns of the row into the values pointed at by dest.
+// It returns sql.ErrNoRows if the query selected no rows.
+func (r *Row) Scan(dest ...interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+	dest = decrypting(r.keys, dest)
+	if r.res != nil {
+		rows := newCachedRows(r.res)
+		if !rows.Next() {
+			return sql.ErrNoRows
+		}
+		return rows.Scan(dest...)
+	}
+	return r.row.Scan(dest...)
+}
+
+// Err returns the error of the query, if any, without scanning the row
+func (r *Row) Err() error {
+	if r.err != nil || r.row == nil {
+		return r.err
+	}
+	return r.row.Err()
+}
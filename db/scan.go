@@ -0,0 +1,319 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrUnmappedColumn is returned when a column of the result has no destination field
+	ErrUnmappedColumn = errors.New("db: column has no destination field")
+	// ErrMissingColumn is returned when a destination field has no column in the result
+	ErrMissingColumn = errors.New("db: field has no column in the result")
+)
+
+var (
+	scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+	timeType    = reflect.TypeOf(time.Time{})
+
+	// structFieldsCache caches the fields of the struct types, by reflect.Type
+	structFieldsCache sync.Map
+)
+
+// structField is a field of a struct mapped to a column
+type structField struct {
+	name  string
+	index []int
+}
+
+// structFields is the set of fields of a struct mapped to columns
+type structFields struct {
+	fields []structField
+	byName map[string]int
+	// ambiguous are the names of several fields at the same depth, none of them being mapped
+	ambiguous map[string]bool
+}
+
+// isLeaf reports whether a value of the type is scanned as a whole
+func isLeaf(t reflect.Type) bool {
+	if t == timeType || reflect.PtrTo(t).Implements(scannerType) {
+		return true
+	}
+	return t.Kind() != reflect.Struct
+}
+
+// embeddedStruct returns the struct type of an untagged embedded field which is flattened, if any:
+// a struct or an exported pointer to a struct, which is allocated when it is scanned
+func embeddedStruct(f reflect.StructField) (reflect.Type, bool) {
+	if _, tagged := f.Tag.Lookup("db"); !f.Anonymous || tagged {
+		return nil, false
+	}
+
+	t := f.Type
+	if t.Kind() == reflect.Ptr {
+		// an unexported pointer can not be allocated
+		if !f.IsExported() {
+			return nil, false
+		}
+		t = t.Elem()
+	}
+	return t, !isLeaf(t)
+}
+
+// fieldsOf returns the mapped fields of a struct type.
+// A field is mapped to the column named by its `db` tag, or to its lowercased name,
+// so that a snake_case column like created_at needs a `db:"created_at"` tag.
+// A field tagged `db:"-"` is ignored, and an untagged embedded struct, or pointer to a struct,
+// is flattened. Like encoding/json, the fields of the outer struct shadow the embedded ones,
+// and the fields of the same name at the same depth are ambiguous, unless only one is tagged.
+func fieldsOf(t reflect.Type) *structFields {
+	if cached, ok := structFieldsCache.Load(t); ok {
+		return cached.(*structFields)
+	}
+
+	type candidate struct {
+		structField
+		tagged bool
+	}
+	var names []string
+	candidates := make(map[string][]candidate)
+	visiting := make(map[reflect.Type]bool)
+
+	var walk func(t reflect.Type, index []int)
+	walk = func(t reflect.Type, index []int) {
+		// a struct embedding itself is walked once
+		if visiting[t] {
+			return
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag, tagged := f.Tag.Lookup("db")
+			if tag == "-" {
+				continue
+			}
+
+			path := make([]int, len(index)+1)
+			copy(path, index)
+			path[len(index)] = i
+
+			if et, ok := embeddedStruct(f); ok {
+				walk(et, path)
+				continue
+			}
+			if !f.IsExported() {
+				continue
+			}
+
+			name := tag
+			if name == "" {
+				name = strings.ToLower(f.Name)
+			}
+			if _, ok := candidates[name]; !ok {
+				names = append(names, name)
+			}
+			candidates[name] = append(candidates[name], candidate{structField{name: name, index: path}, tagged})
+		}
+	}
+	walk(t, nil)
+
+	sf := &structFields{byName: make(map[string]int), ambiguous: make(map[string]bool)}
+	for _, name := range names {
+		// the shallowest fields win, the tagged one if they are several
+		var shallowest []candidate
+		for _, c := range candidates[name] {
+			switch {
+			case len(shallowest) == 0 || len(c.index) < len(shallowest[0].index):
+				shallowest = []candidate{c}
+			case len(c.index) == len(shallowest[0].index):
+				shallowest = append(shallowest, c)
+			}
+		}
+
+		winner, n := shallowest[0], 0
+		if len(shallowest) > 1 {
+			for _, c := range shallowest {
+				if c.tagged {
+					winner, n = c, n+1
+				}
+			}
+			if n != 1 {
+				sf.ambiguous[name] = true
+				continue
+			}
+		}
+
+		sf.byName[name] = len(sf.fields)
+		sf.fields = append(sf.fields, winner.structField)
+	}
+
+	cached, _ := structFieldsCache.LoadOrStore(t, sf)
+	return cached.(*structFields)
+}
+
+// fieldValue returns the value of a mapped field of a struct,
+// nil if it belongs to an embedded struct whose pointer is nil
+func fieldValue(v reflect.Value, index []int) interface{} {
+	f, err := v.FieldByIndexErr(index)
+	if err != nil {
+		return nil
+	}
+	return f.Interface()
+}
+
+// fieldAddr returns the address of a mapped field of a struct,
+// allocating the nil pointers to the embedded structs on its way
+func fieldAddr(v reflect.Value, index []int) interface{} {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v.Addr().Interface()
+}
+
+// rowScanner scans rows of a result into values of T
+type rowScanner[T any] struct {
+	// indexes are the field indexes of the columns, nil if T is scanned as a whole
+	indexes [][]int
+	// ptr is true if T is a pointer to the scanned struct
+	ptr bool
+}
+
+// newRowScanner maps the columns of a result to the fields of T
+func newRowScanner[T any](columns []string) (*rowScanner[T], error) {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	rs := &rowScanner[T]{}
+
+	if t.Kind() == reflect.Ptr && !isLeaf(t.Elem()) {
+		t = t.Elem()
+		rs.ptr = true
+	}
+
+	// T is scanned as a whole from a single column
+	if isLeaf(t) {
+		if len(columns) != 1 {
+			return nil, fmt.Errorf("db: %d columns can not be scanned into %s", len(columns), t)
+		}
+		return rs, nil
+	}
+
+	fields := fieldsOf(t)
+	seen := make(map[string]bool, len(columns))
+	rs.indexes = make([][]int, len(columns))
+	for i, column := range columns {
+		at, ok := fields.byName[column]
+		if !ok && fields.ambiguous[column] {
+			return nil, fmt.Errorf("%w: %s is ambiguous in %s", ErrUnmappedColumn, column, t)
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: %s in %s", ErrUnmappedColumn, column, t)
+		}
+		rs.indexes[i] = fields.fields[at].index
+		seen[column] = true
+	}
+	for _, f := range fields.fields {
+		if !seen[f.name] {
+			return nil, fmt.Errorf("%w: %s in %s", ErrMissingColumn, f.name, t)
+		}
+	}
+
+	return rs, nil
+}
+
+// scan scans the current row into a new T
+func (rs *rowScanner[T]) scan(s Scanable) (T, error) {
+	var v T
+	if rs.indexes == nil {
+		err := s.Scan(&v)
+		return v, err
+	}
+
+	target := reflect.ValueOf(&v).Elem()
+	if rs.ptr {
+		target.Set(reflect.New(target.Type().Elem()))
+		target = target.Elem()
+	}
+
+	dest := make([]interface{}, len(rs.indexes))
+	for i, index := range rs.indexes {
+		dest[i] = fieldAddr(target, index)
+	}
+
+	err := s.Scan(dest...)
+	return v, err
+}
+
+// Get executes a query and scans its first row into a T.
+// It returns sql.ErrNoRows if the query selects no rows.
+func Get[T any](ctx context.Context, db *DB, query string, args ...interface{}) (T, error) {
+	var v T
+
+	rows, err := db.rows(ctx, query, args)
+	if err != nil {
+		return v, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return v, err
+	}
+	rs, err := newRowScanner[T](columns)
+	if err != nil {
+		return v, err
+	}
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return v, err
+		}
+		return v, sql.ErrNoRows
+	}
+	if v, err = rs.scan(rows); err != nil {
+		return v, err
+	}
+
+	return v, rows.Close()
+}
+
+// Select executes a query and scans all of its rows into a slice of T.
+func Select[T any](ctx context.Context, db *DB, query string, args ...interface{}) ([]T, error) {
+	rows, err := db.rows(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	rs, err := newRowScanner[T](columns)
+	if err != nil {
+		return nil, err
+	}
+
+	vs := make([]T, 0)
+	for rows.Next() {
+		v, err := rs.scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		vs = append(vs, v)
+	}
+
+	return vs, rows.Err()
+}
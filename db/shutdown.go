@@ -0,0 +1,193 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrShuttingDown is returned when a transaction is started while the database is shutting down
+var ErrShuttingDown = errors.New("db: database is shutting down")
+
+// pkgPrefix is the prefix of the functions of the package, skipped in the callers of the transactions
+var pkgPrefix = reflect.TypeOf(DB{}).PkgPath() + "."
+
+// TxInfo describes a transaction in flight
+type TxInfo struct {
+	// Caller is the function that started the transaction, with its location
+	Caller string
+	// Started is the time the transaction has started at
+	Started time.Time
+}
+
+func (i TxInfo) String() string {
+	return fmt.Sprintf("%s (open for %s)", i.Caller, time.Since(i.Started).Round(time.Millisecond))
+}
+
+// inflight is a transaction in flight, tracked until it has ended
+type inflight struct {
+	started time.Time
+	// pcs is the stack of the caller, resolved only when reported: its first frames for the registry,
+	// held by callers, or the whole stack if the long transactions are logged
+	pcs     []uintptr
+	callers [callerDepth]uintptr
+	// cancel cancels the context of the transaction, which rolls it back
+	cancel context.CancelFunc
+}
+
+// txRegistry tracks the transactions in flight
+type txRegistry struct {
+	mu       sync.Mutex
+	txs      map[*inflight]struct{}
+	closing  bool
+	drained  chan struct{}
+	closeErr error
+	closed   bool
+}
+
+// track registers a new transaction, unless the database is shutting down.
+// It returns the context of the transaction, canceled on a forced shutdown.
+func (db *DB) track(ctx context.Context) (context.Context, *inflight, error) {
+	r := &db.registry
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closing {
+		return ctx, nil, ErrShuttingDown
+	}
+	if r.txs == nil {
+		r.txs = make(map[*inflight]struct{})
+	}
+
+	// skip runtime.Callers, track and WithTransaction
+	f := &inflight{started: time.Now()}
+	f.pcs = f.callers[:]
+	if db.LongTransactionThreshold > 0 {
+		f.pcs = make([]uintptr, maxStackDepth)
+	}
+	f.pcs = f.pcs[:runtime.Callers(3, f.pcs)]
+	ctx, f.cancel = context.WithCancel(ctx)
+	r.txs[f] = struct{}{}
+	return ctx, f, nil
+}
+
+// untrack unregisters an ended transaction
+func (db *DB) untrack(f *inflight) {
+	r := &db.registry
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f.cancel()
+	delete(r.txs, f)
+	if len(r.txs) == 0 && r.drained != nil {
+		close(r.drained)
+		r.drained = nil
+	}
+}
+
+// OpenTransactions returns the transactions in flight, the oldest first
+func (db *DB) OpenTransactions() []TxInfo {
+	r := &db.registry
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	infos := make([]TxInfo, 0, len(r.txs))
+	for f := range r.txs {
+		infos = append(infos, TxInfo{Caller: f.caller(), Started: f.started})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Started.Before(infos[j].Started) })
+	return infos
+}
+
+// caller returns the first function of the stack outside of the package
+func (f *inflight) caller() string {
+	frames := runtime.CallersFrames(f.pcs)
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, pkgPrefix) {
+			return fmt.Sprintf("%s %s:%d", frame.Function, frame.File, frame.Line)
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
+
+// Shutdown stops starting new transactions, waits for the transactions in flight to end
+// and closes the database. If the context is done before, the transactions still open
+// are rolled back, the database is closed, and they are reported in the returned error.
+// Only the transactions of WithTransaction, and of Exec outside of autocommit, are drained:
+// the queries, the statements in autocommit, and the rows and iterators still open are not
+// waited for, and fail once the database is closed.
+func (db *DB) Shutdown(ctx context.Context) error {
+	r := &db.registry
+	r.mu.Lock()
+	r.closing = true
+	var drained chan struct{}
+	if len(r.txs) > 0 {
+		if r.drained == nil {
+			r.drained = make(chan struct{})
+		}
+		drained = r.drained
+	}
+	r.mu.Unlock()
+
+	if drained == nil {
+		return db.close()
+	}
+
+	select {
+	case <-drained:
+		return db.close()
+	case <-ctx.Done():
+	}
+
+	// force the open transactions to end: their statements fail, they can not commit anymore,
+	// and they are rolled back by the driver in the background
+	open := db.OpenTransactions()
+	r.mu.Lock()
+	for f := range r.txs {
+		f.cancel()
+	}
+	r.mu.Unlock()
+
+	callers := make([]string, len(open))
+	for i, info := range open {
+		callers[i] = info.String()
+	}
+	err := fmt.Errorf("db: %d transactions still open: %s: %w", len(open), strings.Join(callers, ", "), ctx.Err())
+	if cerr := db.close(); cerr != nil {
+		return fmt.Errorf("%v: %w", err, cerr)
+	}
+	return err
+}
+
+// close closes the database connections, without waiting for the transactions in flight.
+// It can be called several times, and returns the error of the first call.
+func (db *DB) close() error {
+	r := &db.registry
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return r.closeErr
+	}
+	r.closing, r.closed = true, true
+
+	for _, c := range db.stmtCaches {
+		c.close()
+	}
+	if db.replicas != nil {
+		r.closeErr = db.replicas.close()
+	}
+	if err := db.DB.Close(); err != nil {
+		r.closeErr = err
+	}
+	return r.closeErr
+}
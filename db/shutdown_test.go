@@ -0,0 +1,107 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// shuttingDown reports whether the database has started to shut down
+func (s *DBTestSuite) shuttingDown() bool {
+	s.db.registry.mu.Lock()
+	defer s.db.registry.mu.Unlock()
+	return s.db.registry.closing
+}
+
+// TestShutdown will test the shutdown waiting for the transactions in flight.
+func (s *DBTestSuite) TestShutdown() {
+	ctx := context.Background()
+
+	// mock
+	s.mock.ExpectBegin()
+	s.mock.ExpectCommit()
+	s.mock.ExpectClose()
+
+	// test
+	started, release := make(chan struct{}), make(chan struct{})
+	txErr := make(chan error)
+	go func() {
+		txErr <- s.db.WithTransaction(ctx, func(context.Context) error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	<-started
+	assert.Len(s.T(), s.db.OpenTransactions(), 1)
+
+	shutdownErr := make(chan error)
+	go func() {
+		ctx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		shutdownErr <- s.db.Shutdown(ctx)
+	}()
+
+	// new transactions are rejected while the transactions in flight end
+	assert.Eventually(s.T(), s.shuttingDown, time.Second, time.Millisecond)
+	assert.ErrorIs(s.T(), s.db.WithTransaction(ctx, func(context.Context) error { return nil }), ErrShuttingDown)
+
+	close(release)
+	assert.NoError(s.T(), <-txErr)
+	assert.NoError(s.T(), <-shutdownErr)
+	assert.Empty(s.T(), s.db.OpenTransactions())
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
+}
+
+// TestShutdownTimeout will test the shutdown forcing the transactions still open to end.
+func (s *DBTestSuite) TestShutdownTimeout() {
+	ctx := context.Background()
+
+	// mock
+	// the transaction is rolled back by the driver in the background, while the database is closed
+	s.mock.MatchExpectationsInOrder(false)
+	s.mock.ExpectBegin()
+	s.mock.ExpectRollback()
+	s.mock.ExpectClose()
+
+	// test
+	started, release := make(chan struct{}), make(chan struct{})
+	txErr := make(chan error)
+	go func() {
+		txErr <- s.db.WithTransaction(ctx, func(context.Context) error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	<-started
+
+	sctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	err := s.db.Shutdown(sctx)
+	assert.ErrorIs(s.T(), err, context.DeadlineExceeded)
+	assert.Contains(s.T(), err.Error(), "1 transactions still open")
+
+	// the context of the transaction has been canceled underneath the callback
+	close(release)
+	assert.ErrorIs(s.T(), <-txErr, context.Canceled)
+	assert.Eventually(s.T(), func() bool { return s.mock.ExpectationsWereMet() == nil }, time.Second, time.Millisecond)
+}
+
+// TestTrackStack will test the whole stack of a transaction captured only if the long transactions are logged.
+func (s *DBTestSuite) TestTrackStack() {
+	ctx := context.Background()
+
+	// test
+	_, f, err := s.db.track(ctx)
+	assert.NoError(s.T(), err)
+	assert.LessOrEqual(s.T(), len(f.pcs), callerDepth)
+	s.db.untrack(f)
+
+	s.db.LongTransactionThreshold = time.Minute
+	_, f, err = s.db.track(ctx)
+	assert.NoError(s.T(), err)
+	assert.Greater(s.T(), len(f.pcs), callerDepth)
+	s.db.untrack(f)
+}
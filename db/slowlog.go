@@ -0,0 +1,145 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/org39/gopkg/log"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	toMilli = 1e6
+
+	// maxStackDepth is the maximum number of frames of a transaction caller stack
+	maxStackDepth = 32
+	// callerDepth is the number of frames searched for the caller of a transaction outside of the package
+	callerDepth = 4
+)
+
+var (
+	placeholderPattern = regexp.MustCompile(`\$\d+|@p\d+`)
+	listPattern        = regexp.MustCompile(`\?(\s*,\s*\?)+`)
+	spacePattern       = regexp.MustCompile(`\s+`)
+)
+
+// WithSlowQueryThreshold is a database option that logs the statements running longer than d
+func WithSlowQueryThreshold(d time.Duration) func(*DB) error {
+	return func(db *DB) error {
+		db.SlowQueryThreshold = d
+		return nil
+	}
+}
+
+// WithSlowTransactionThreshold is a database option that logs the transactions running longer than d
+func WithSlowTransactionThreshold(d time.Duration) func(*DB) error {
+	return func(db *DB) error {
+		db.SlowTransactionThreshold = d
+		return nil
+	}
+}
+
+// WithLongTransactionThreshold is a database option that logs the transactions still open after d,
+// with the stack of their caller
+func WithLongTransactionThreshold(d time.Duration) func(*DB) error {
+	return func(db *DB) error {
+		db.LongTransactionThreshold = d
+		return nil
+	}
+}
+
+// fingerprint returns the shape of a query, without literals nor varying lists of placeholders
+func (d Dialect) fingerprint(query string) string {
+	fp := d.sanitize(query)
+	fp = placeholderPattern.ReplaceAllString(fp, "?")
+	fp = listPattern.ReplaceAllString(fp, "?+")
+	fp = spacePattern.ReplaceAllString(fp, " ")
+	return strings.TrimSpace(fp)
+}
+
+// redactArgs summarizes the arguments of a query by their types, without their values
+func redactArgs(args []interface{}) string {
+	summary := make([]string, len(args))
+	for i, arg := range args {
+		v := reflect.ValueOf(arg)
+		switch {
+		case arg == nil:
+			summary[i] = "<nil>"
+		case v.Kind() == reflect.String || v.Kind() == reflect.Slice:
+			summary[i] = fmt.Sprintf("%T(%d)", arg, v.Len())
+		default:
+			summary[i] = fmt.Sprintf("%T", arg)
+		}
+	}
+	return "[" + strings.Join(summary, " ") + "]"
+}
+
+// logSlowQuery logs a statement running longer than the threshold
+func (db *DB) logSlowQuery(ctx context.Context, query string, args []interface{}, duration time.Duration) {
+	if db.SlowQueryThreshold <= 0 || duration < db.SlowQueryThreshold {
+		return
+	}
+
+	log.LoggerWithSpan(ctx).WithFields(logrus.Fields{
+		"db_fingerprint":    db.Dialect.fingerprint(query),
+		"db_args":           redactArgs(args),
+		"db_latency":        float64(duration) / float64(toMilli),
+		"db_latency_human":  duration.String(),
+		"db_slow_threshold": db.SlowQueryThreshold.String(),
+	}).Warn("db: slow query")
+}
+
+// logSlowTransaction logs a transaction running longer than the threshold
+func (db *DB) logSlowTransaction(ctx context.Context, duration time.Duration, err error) {
+	if db.SlowTransactionThreshold <= 0 || duration < db.SlowTransactionThreshold {
+		return
+	}
+
+	entry := log.LoggerWithSpan(ctx).WithFields(logrus.Fields{
+		"db_latency":        float64(duration) / float64(toMilli),
+		"db_latency_human":  duration.String(),
+		"db_slow_threshold": db.SlowTransactionThreshold.String(),
+	})
+	if err != nil {
+		entry = entry.WithError(err)
+	}
+	entry.Warn("db: slow transaction")
+}
+
+// watchLongTransaction logs the tracked transaction if it is still open after the threshold,
+// with the stack of its caller. The returned function stops the watch.
+func (db *DB) watchLongTransaction(ctx context.Context, f *inflight) func() {
+	if db.LongTransactionThreshold <= 0 {
+		return func() {}
+	}
+
+	timer := time.AfterFunc(db.LongTransactionThreshold, func() {
+		log.LoggerWithSpan(ctx).WithFields(logrus.Fields{
+			"db_open_for":       time.Since(f.started).String(),
+			"db_long_threshold": db.LongTransactionThreshold.String(),
+			"db_stack":          formatStack(f.pcs),
+		}).Warn("db: long transaction")
+	})
+
+	return func() { timer.Stop() }
+}
+
+// formatStack formats the frames of a stack, one function and its location per line
+func formatStack(pcs []uintptr) string {
+	var b strings.Builder
+	frames := runtime.CallersFrames(pcs)
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&b, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,235 @@
+package db
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+)
+
+// StmtCacheStats are the statistics of the prepared statement cache
+type StmtCacheStats struct {
+	// Size is the number of cached statements.
+	Size int
+	// Hits is the number of queries served by a cached statement.
+	Hits uint64
+	// Misses is the number of queries which prepared a statement.
+	Misses uint64
+	// Evictions is the number of statements evicted from the cache.
+	Evictions uint64
+}
+
+// WithStmtCache is a database option that caches up to size prepared statements
+// per connection pool, keyed by query text
+func WithStmtCache(size int) func(*DB) error {
+	return func(db *DB) error {
+		if size < 1 {
+			return fmt.Errorf("db: invalid statement cache size %d", size)
+		}
+		db.StmtCacheSize = size
+		return nil
+	}
+}
+
+// cachedStmt is a prepared statement of the cache
+type cachedStmt struct {
+	query string
+	stmt  *sql.Stmt
+
+	// refs is the number of queries using the statement,
+	// an evicted statement is closed once it is not used anymore
+	refs    int
+	evicted bool
+}
+
+// stmtCache is a LRU cache of the prepared statements of a connection pool
+type stmtCache struct {
+	db       *sql.DB
+	capacity int
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	stats   StmtCacheStats
+}
+
+func newStmtCache(db *sql.DB, capacity int) *stmtCache {
+	return &stmtCache{
+		db:       db,
+		capacity: capacity,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// lookup returns the prepared statement of the query if it is cached, without preparing it.
+// The statement must be released once the query has been executed.
+func (c *stmtCache) lookup(query string) (*cachedStmt, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[query]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+	e := el.Value.(*cachedStmt)
+	e.refs++
+	c.lru.MoveToFront(el)
+	c.stats.Hits++
+	return e, true
+}
+
+// acquire returns the prepared statement of the query, preparing it on a miss.
+// The statement must be released once the query has been executed.
+func (c *stmtCache) acquire(ctx context.Context, query string) (*cachedStmt, error) {
+	if e, ok := c.lookup(query); ok {
+		return e, nil
+	}
+
+	// prepare without holding the lock
+	stmt, err := c.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// the statement may have been prepared concurrently
+	if el, ok := c.entries[query]; ok {
+		e := el.Value.(*cachedStmt)
+		e.refs++
+		c.lru.MoveToFront(el)
+		stmt.Close()
+		return e, nil
+	}
+
+	e := &cachedStmt{query: query, stmt: stmt, refs: 1}
+	c.entries[query] = c.lru.PushFront(e)
+	for c.lru.Len() > c.capacity {
+		c.evict(c.lru.Back())
+	}
+	return e, nil
+}
+
+// release releases a statement acquired by a query
+func (c *stmtCache) release(e *cachedStmt) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e.refs--
+	if e.evicted && e.refs == 0 {
+		e.stmt.Close()
+	}
+}
+
+// evict removes a statement from the cache, it is closed once it is not used anymore
+func (c *stmtCache) evict(el *list.Element) {
+	e := el.Value.(*cachedStmt)
+	c.lru.Remove(el)
+	delete(c.entries, e.query)
+	c.stats.Evictions++
+
+	e.evicted = true
+	if e.refs == 0 {
+		e.stmt.Close()
+	}
+}
+
+// close evicts all statements
+func (c *stmtCache) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for c.lru.Len() > 0 {
+		c.evict(c.lru.Back())
+	}
+}
+
+func (c *stmtCache) snapshot() StmtCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Size = c.lru.Len()
+	return stats
+}
+
+// StmtCacheStats returns the statistics of the prepared statement caches
+func (db *DB) StmtCacheStats() StmtCacheStats {
+	var stats StmtCacheStats
+	for _, c := range db.stmtCaches {
+		s := c.snapshot()
+		stats.Size += s.Size
+		stats.Hits += s.Hits
+		stats.Misses += s.Misses
+		stats.Evictions += s.Evictions
+	}
+	return stats
+}
+
+// querier executes queries on a connection pool, a transaction or a prepared statement
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// stmtQuerier executes the query of a prepared statement
+type stmtQuerier struct {
+	stmt *sql.Stmt
+}
+
+func (q stmtQuerier) QueryRowContext(ctx context.Context, _ string, args ...interface{}) *sql.Row {
+	return q.stmt.QueryRowContext(ctx, args...)
+}
+
+func (q stmtQuerier) QueryContext(ctx context.Context, _ string, args ...interface{}) (*sql.Rows, error) {
+	return q.stmt.QueryContext(ctx, args...)
+}
+
+func (q stmtQuerier) ExecContext(ctx context.Context, _ string, args ...interface{}) (sql.Result, error) {
+	return q.stmt.ExecContext(ctx, args...)
+}
+
+// querier returns the querier of a query in the context: the transaction of the context,
+// or the connection pool serving it, with its cached prepared statement if any.
+// The returned function must be called once the query has been executed.
+func (db *DB) querier(ctx context.Context, query string, write bool) (querier, func()) {
+	var q querier
+	h := db.DB
+	t, inTx := txFromContext(ctx)
+	switch {
+	case inTx:
+		q = t.tx
+	case write:
+		q = h
+	default:
+		h = db.reader(ctx)
+		q = h
+	}
+
+	c, ok := db.stmtCaches[h]
+	if !ok {
+		return q, func() {}
+	}
+
+	// a statement already cached is rebound to the transaction, which holds its connection:
+	// preparing a missing one on the pool would need a second connection
+	if inTx {
+		e, ok := c.lookup(query)
+		if !ok {
+			return q, func() {}
+		}
+		return stmtQuerier{t.tx.StmtContext(ctx, e.stmt)}, func() { c.release(e) }
+	}
+
+	// the query is prepared as is by the driver if it can not be cached
+	e, err := c.acquire(ctx, query)
+	if err != nil {
+		return q, func() {}
+	}
+	return stmtQuerier{e.stmt}, func() { c.release(e) }
+}
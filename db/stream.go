@@ -0,0 +1,170 @@
+package db
+
+import (
+	"context"
+)
+
+// Iterator iterates over the rows of a query, scanning every row into a T.
+// It is closed once the rows are exhausted, on error, or when its context is cancled,
+// and must be closed by the caller if it stops iterating early.
+//
+//	it, err := db.Stream[User](ctx, d, "SELECT * FROM `users`")
+//	if err != nil {
+//		return err
+//	}
+//	defer it.Close()
+//	for it.Next() {
+//		user := it.Value()
+//	}
+//	return it.Err()
+type Iterator[T any] struct {
+	ctx   context.Context
+	rows  resultRows
+	scan  func(Scanable) (T, error)
+	value T
+	err   error
+}
+
+// Stream executes a query and returns an iterator over its rows, scanned like Select.
+func Stream[T any](ctx context.Context, db *DB, query string, args ...interface{}) (*Iterator[T], error) {
+	rows, err := db.rows(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	columns, err := rows.Columns()
+	if err != nil {
+		rows.Close()
+		return nil, err
+	}
+	rs, err := newRowScanner[T](columns)
+	if err != nil {
+		rows.Close()
+		return nil, err
+	}
+
+	return &Iterator[T]{ctx: ctx, rows: rows, scan: rs.scan}, nil
+}
+
+// StreamFunc executes a query and returns an iterator over its rows,
+// scanned by the given function.
+func StreamFunc[T any](ctx context.Context, db *DB, scan func(Scanable) (T, error), query string, args ...interface{}) (*Iterator[T], error) {
+	rows, err := db.rows(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Iterator[T]{ctx: ctx, rows: rows, scan: scan}, nil
+}
+
+// Next advances the iterator to the next row, it returns false once the iteration has ended.
+func (it *Iterator[T]) Next() bool {
+	if it.rows == nil {
+		return false
+	}
+
+	if err := it.ctx.Err(); err != nil {
+		it.close(err)
+		return false
+	}
+	if !it.rows.Next() {
+		it.close(it.rows.Err())
+		return false
+	}
+
+	v, err := it.scan(it.rows)
+	if err != nil {
+		it.close(err)
+		return false
+	}
+	it.value = v
+
+	return true
+}
+
+// Value returns the value of the current row
+func (it *Iterator[T]) Value() T {
+	return it.value
+}
+
+// Err returns the error that ended the iteration, if any
+func (it *Iterator[T]) Err() error {
+	return it.err
+}
+
+// Close closes the iterator, it can be called several times
+func (it *Iterator[T]) Close() error {
+	it.close(nil)
+	return it.err
+}
+
+// close ends the iteration with the given error
+func (it *Iterator[T]) close(err error) {
+	if it.rows == nil {
+		return
+	}
+
+	cerr := it.rows.Close()
+	it.rows = nil
+	var zero T
+	it.value = zero
+
+	if err == nil {
+		err = cerr
+	}
+	it.err = err
+}
+
+// Batches iterates over the rows of a query in batches of at most size values.
+type Batches[T any] struct {
+	it    *Iterator[T]
+	size  int
+	batch []T
+}
+
+// StreamBatches executes a query and returns an iterator over batches of its rows,
+// scanned like Select. Every batch is a new slice, that the caller may keep.
+func StreamBatches[T any](ctx context.Context, db *DB, size int, query string, args ...interface{}) (*Batches[T], error) {
+	it, err := Stream[T](ctx, db, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	if size < 1 {
+		size = 1
+	}
+	return &Batches[T]{it: it, size: size}, nil
+}
+
+// Next advances the iterator to the next batch, it returns false once the iteration has ended.
+// The last batch may be shorter than the batch size.
+func (b *Batches[T]) Next() bool {
+	b.batch = nil
+	for len(b.batch) < b.size && b.it.Next() {
+		if b.batch == nil {
+			b.batch = make([]T, 0, b.size)
+		}
+		b.batch = append(b.batch, b.it.Value())
+	}
+
+	// a partial batch is not yielded if the iteration failed
+	if b.it.Err() != nil {
+		b.batch = nil
+	}
+	return len(b.batch) > 0
+}
+
+// Value returns the current batch
+func (b *Batches[T]) Value() []T {
+	return b.batch
+}
+
+// Err returns the error that ended the iteration, if any
+func (b *Batches[T]) Err() error {
+	return b.it.Err()
+}
+
+// Close closes the iterator, it can be called several times
+func (b *Batches[T]) Close() error {
+	return b.it.Close()
+}
@@ -0,0 +1,152 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracerName = "github.com/org39/gopkg/db"
+
+	spanQuery       = "db.Query"
+	spanQueryRow    = "db.QueryRow"
+	spanExec        = "db.Exec"
+	spanTransaction = "db.Transaction"
+	spanSavepoint   = "db.Savepoint"
+)
+
+// dbRowsAffectedKey is the number of rows affected by a statement
+var dbRowsAffectedKey = attribute.Key("db.rows_affected")
+
+// WithTracerProvider is a database option that sets the tracer provider of the database spans,
+// the global tracer provider is used by default
+func WithTracerProvider(tp trace.TracerProvider) func(*DB) error {
+	return func(db *DB) error {
+		db.TracerProvider = tp
+		return nil
+	}
+}
+
+// system returns the db.system attribute of the dialect
+func (d Dialect) system() attribute.KeyValue {
+	switch d {
+	case MySQL:
+		return semconv.DBSystemMySQL
+	case Postgres:
+		return semconv.DBSystemPostgreSQL
+	case SQLite:
+		return semconv.DBSystemSqlite
+	case SQLServer:
+		return semconv.DBSystemMSSQL
+	default:
+		return semconv.DBSystemOtherSQL
+	}
+}
+
+// sanitize replaces the string and numeric literals of a query by ?,
+// so that no value leaks out of the database. The quoting rules follow the dialect:
+// double quotes delimit strings in MySQL, and PostgreSQL has escape and dollar-quoted strings.
+func (d Dialect) sanitize(query string) string {
+	var b strings.Builder
+	b.Grow(len(query))
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case d.stringQuote(c) || d.escapeString(query, i+1) || d.dollarQuote(query, i) != "":
+			// the prefix of an escape string is part of the literal
+			b.WriteByte('?')
+			if d.escapeString(query, i+1) {
+				i++
+			}
+			i = d.skipLiteral(query, i)
+		case c == '"' || c == '`' || strings.HasPrefix(query[i:], "--") || strings.HasPrefix(query[i:], "/*"):
+			// quoted identifiers and comments are kept as is
+			end := d.skipLiteral(query, i)
+			b.WriteString(query[i:end])
+			i = end
+		case c >= '0' && c <= '9' && (i == 0 || !isNamePart(query[i-1]) && query[i-1] != '$'):
+			b.WriteByte('?')
+			for i < len(query) && (isNamePart(query[i]) || query[i] == '.') {
+				i++
+			}
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String()
+}
+
+// operation returns the SQL keyword of a query, like SELECT or INSERT
+func operation(query string) string {
+	query = strings.TrimSpace(query)
+	if i := strings.IndexAny(query, " \t\r\n("); i >= 0 {
+		query = query[:i]
+	}
+	return strings.ToUpper(query)
+}
+
+func (db *DB) tracer() trace.Tracer {
+	tp := db.TracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return tp.Tracer(tracerName)
+}
+
+// startSpan starts a database span following the OpenTelemetry semantic conventions
+func (db *DB) startSpan(ctx context.Context, name string, query string) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{db.Dialect.system()}
+	if db.Name != "" {
+		attrs = append(attrs, semconv.DBNameKey.String(db.Name))
+	}
+	if query != "" {
+		attrs = append(attrs,
+			semconv.DBStatementKey.String(db.Dialect.sanitize(query)),
+			semconv.DBOperationKey.String(operation(query)),
+		)
+	}
+
+	return db.tracer().Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+// endSpan ends a database span with the status of the operation
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// endSpanWithPanic ends a database span with the status of the operation,
+// and repanics if the operation has panic
+func endSpanWithPanic(span trace.Span, err error, r interface{}) {
+	if r != nil {
+		endSpan(span, fmt.Errorf("panic: %v", r))
+		panic(r)
+	}
+	endSpan(span, err)
+}
+
+// endExecSpan ends a database span of a statement with the number of rows affected
+func endExecSpan(span trace.Span, res sql.Result, err error) {
+	if err == nil && res != nil {
+		if n, rerr := res.RowsAffected(); rerr == nil {
+			span.SetAttributes(dbRowsAffectedKey.Int64(n))
+		}
+	}
+	endSpan(span, err)
+}
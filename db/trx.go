@@ -1,28 +1,68 @@
 package db
 
-import "context"
-
-func doTx(txCtx context.Context, fn func(context.Context) error) (chan interface{}, chan error) {
-	cpanic := make(chan interface{})
-	cerr := make(chan error)
-
-	go func() {
-		// defers are execute in LIFO (last in, first out) order
-		defer close(cpanic)
-		defer close(cerr)
-
-		defer func() {
-			// if panic occurs
-			// report panic to the caller
-			if r := recover(); r != nil {
-				cpanic <- r
-			}
-		}()
-
-		// if callback is finished normally
-		// report result to the caller
-		cerr <- fn(txCtx)
-	}()
-
-	return cpanic, cerr
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// transaction is the state of a transaction carried by the context
+type transaction struct {
+	tx   *sql.Tx
+	opts txOptions
+
+	// savepoints is the number of savepoints created so far,
+	// used to give every savepoint a unique name
+	savepoints int
+
+	// mu guards the hooks, registered by the callback
+	mu sync.Mutex
+	// afterCommit and afterRollback are the hooks run once the transaction has ended
+	afterCommit   []func(context.Context)
+	afterRollback []func(context.Context)
+	// rolledBack are the rollback hooks of the savepoints rolled back so far,
+	// run once the transaction has ended whatever its outcome
+	rolledBack []func(context.Context)
+}
+
+// txFromContext returns the transaction carried by the context
+func txFromContext(ctx context.Context) (*transaction, bool) {
+	t, ok := ctx.Value(txKey).(*transaction)
+	return t, ok
+}
+
+// InTransaction reports whether a transaction is started in the context
+func InTransaction(ctx context.Context) bool {
+	_, ok := txFromContext(ctx)
+	return ok
+}
+
+// nextSavepoint returns a new unique savepoint name
+func (t *transaction) nextSavepoint() string {
+	t.savepoints++
+	return fmt.Sprintf("sp_%d", t.savepoints)
+}
+
+// doTx executes the callback of a transaction.
+// The callback is not interrupted when the parent context is cancled,
+// the statements it executes fail instead, and the cancelation is reported
+// once it returns, whatever its result.
+func doTx(ctx context.Context, txCtx context.Context, fn func(context.Context) error) error {
+	err := fn(txCtx)
+
+	cerr := ctx.Err()
+	if deadline, ok := ctx.Deadline(); ok && cerr == nil && !time.Now().Before(deadline) {
+		// the deadline has passed, but its timer may not have fired yet
+		cerr = context.DeadlineExceeded
+	}
+	if cerr != nil {
+		if err == nil || errors.Is(err, cerr) {
+			return cerr
+		}
+		return fmt.Errorf("%v: %w", err, cerr)
+	}
+	return err
 }
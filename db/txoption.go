@@ -0,0 +1,79 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrIncompatibleTxOptions is returned when a nested transaction asks for
+// stricter options than the outer transaction has
+var ErrIncompatibleTxOptions = errors.New("db: transaction options are stricter than the outer transaction")
+
+// TxOption is a transaction option
+type TxOption func(*txOptions)
+
+type txOptions struct {
+	isolation sql.IsolationLevel
+	readOnly  bool
+	timeout   time.Duration
+}
+
+// Isolation is a transaction option that sets the isolation level
+func Isolation(level sql.IsolationLevel) TxOption {
+	return func(o *txOptions) {
+		o.isolation = level
+	}
+}
+
+// ReadOnly is a transaction option that makes the transaction read-only
+func ReadOnly() TxOption {
+	return func(o *txOptions) {
+		o.readOnly = true
+	}
+}
+
+// Timeout is a transaction option that sets the maximum duration of the transaction
+func Timeout(d time.Duration) TxOption {
+	return func(o *txOptions) {
+		o.timeout = d
+	}
+}
+
+func newTxOptions(opts []TxOption) txOptions {
+	o := txOptions{isolation: sql.LevelDefault}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// sqlTxOptions converts the options to the standard transaction options
+func (o txOptions) sqlTxOptions() *sql.TxOptions {
+	return &sql.TxOptions{Isolation: o.isolation, ReadOnly: o.readOnly}
+}
+
+// withTimeout derives a context bounded by the transaction timeout
+func (o txOptions) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if o.timeout > 0 {
+		return context.WithTimeout(ctx, o.timeout)
+	}
+	return ctx, func() {}
+}
+
+// compatible checks if the options of a nested transaction are satisfied by the outer transaction
+func (o txOptions) compatible(outer txOptions) error {
+	// isolation levels are ordered from the weakest to the strictest,
+	// the default level of the outer transaction is unknown so it satisfies nothing
+	if o.isolation != sql.LevelDefault && o.isolation > outer.isolation {
+		return fmt.Errorf("%w: isolation level %s, outer %s", ErrIncompatibleTxOptions, o.isolation, outer.isolation)
+	}
+
+	if o.readOnly && !outer.readOnly {
+		return fmt.Errorf("%w: read-only, outer read-write", ErrIncompatibleTxOptions)
+	}
+
+	return nil
+}